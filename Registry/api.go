@@ -0,0 +1,178 @@
+package Registry
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// apiError JSON API 出错时的响应体
+type apiError struct {
+	Error string `json:"error"`
+}
+
+// serviceSummary GET /v1/services 中的一项
+type serviceSummary struct {
+	Name      string `json:"name"`
+	Instances int    `json:"instances"`
+}
+
+type servicesResponse struct {
+	Revision uint64           `json:"revision"`
+	Services []serviceSummary `json:"services"`
+}
+
+// APIHandler 返回 JSON API 的 handler，路径均以 /v1 开头：
+//
+//	GET    /v1/services                                  列出全部服务
+//	GET    /v1/services/{name}/instances                 列出服务的实例，带 watch=版本号 时为长轮询，epoch 为版本号所属的 epoch，timeout 为等待时长
+//	POST   /v1/services/{name}/instances                 注册实例，请求体为 ServerItem
+//	PUT    /v1/services/{name}/instances/{addr}/heartbeat 续约实例
+//	DELETE /v1/services/{name}/instances/{addr}           注销实例
+//	PUT    /v1/services/{name}/instances/{addr}/drain     摘除实例的流量
+//	DELETE /v1/services/{name}/instances/{addr}/drain     恢复实例的流量
+func (r *ORegistry) APIHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/services", r.listServices)
+	mux.HandleFunc("GET /v1/services/{name}/instances", r.listInstances)
+	mux.HandleFunc("POST /v1/services/{name}/instances", r.registerInstance)
+	mux.HandleFunc("PUT /v1/services/{name}/instances/{addr}/heartbeat", r.heartbeatInstance)
+	mux.HandleFunc("DELETE /v1/services/{name}/instances/{addr}", r.deregisterInstance)
+	mux.HandleFunc("PUT /v1/services/{name}/instances/{addr}/drain", r.drainInstance)
+	mux.HandleFunc("DELETE /v1/services/{name}/instances/{addr}/drain", r.drainInstance)
+	mux.HandleFunc("/", func(w http.ResponseWriter, req *http.Request) {
+		writeError(w, http.StatusNotFound, "no such endpoint: "+req.URL.Path)
+	})
+	return mux
+}
+
+func writeError(w http.ResponseWriter, code int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(apiError{Error: msg})
+}
+
+func (r *ORegistry) listServices(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	r.expire()
+	resp := servicesResponse{Revision: r.revision, Services: make([]serviceSummary, 0, len(r.Services))}
+	for name, instances := range r.Services {
+		if name == "" {
+			continue
+		}
+		resp.Services = append(resp.Services, serviceSummary{Name: name, Instances: len(instances)})
+	}
+	r.mu.Unlock()
+	sort.Slice(resp.Services, func(i, j int) bool { return resp.Services[i].Name < resp.Services[j].Name })
+	writeJSON(w, resp)
+}
+
+func (r *ORegistry) listInstances(w http.ResponseWriter, req *http.Request) {
+	name := req.PathValue("name")
+	query := req.URL.Query()
+	if query.Get("watch") == "" {
+		r.mu.Lock()
+		r.expire()
+		resp := watchResponse{Epoch: r.epoch, Revision: r.revision, Full: true, Instances: r.instances(name)}
+		r.mu.Unlock()
+		writeJSON(w, resp)
+		return
+	}
+	revision, err := strconv.ParseUint(query.Get("watch"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid watch revision: "+query.Get("watch"))
+		return
+	}
+	timeout := defaultWatchTimeout
+	if t := query.Get("timeout"); t != "" {
+		if timeout, err = time.ParseDuration(t); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid timeout: "+t)
+			return
+		}
+	}
+	writeJSON(w, r.watchDelta(req, name, query.Get("epoch"), revision, timeout))
+}
+
+func (r *ORegistry) registerInstance(w http.ResponseWriter, req *http.Request) {
+	var item ServerItem
+	if err := json.NewDecoder(req.Body).Decode(&item); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid instance: "+err.Error())
+		return
+	}
+	if !strings.Contains(item.Addr, "@") {
+		writeError(w, http.StatusBadRequest, "invalid addr, expect protocol@addr: "+item.Addr)
+		return
+	}
+	if r.redirect(w, req) {
+		return
+	}
+	item.Service = req.PathValue("name")
+	created, err := r.Register(item)
+	if err != nil {
+		r.writeCommitError(w, req, err)
+		return
+	}
+	code := http.StatusOK
+	if created {
+		code = http.StatusCreated
+	}
+	r.mu.Lock()
+	if s := r.Services[item.Service][item.Addr]; s != nil {
+		item = *s
+	}
+	r.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(item)
+}
+
+func (r *ORegistry) heartbeatInstance(w http.ResponseWriter, req *http.Request) {
+	if r.redirect(w, req) {
+		return
+	}
+	ok, err := r.Heartbeat(req.PathValue("name"), req.PathValue("addr"))
+	if err != nil {
+		r.writeCommitError(w, req, err)
+		return
+	}
+	if !ok {
+		writeError(w, http.StatusNotFound, "instance not registered: "+req.PathValue("addr"))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (r *ORegistry) deregisterInstance(w http.ResponseWriter, req *http.Request) {
+	if r.redirect(w, req) {
+		return
+	}
+	ok, err := r.Deregister(req.PathValue("name"), req.PathValue("addr"))
+	if err != nil {
+		r.writeCommitError(w, req, err)
+		return
+	}
+	if !ok {
+		writeError(w, http.StatusNotFound, "instance not registered: "+req.PathValue("addr"))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (r *ORegistry) drainInstance(w http.ResponseWriter, req *http.Request) {
+	if r.redirect(w, req) {
+		return
+	}
+	ok, err := r.Drain(req.PathValue("name"), req.PathValue("addr"), req.Method == "PUT")
+	if err != nil {
+		r.writeCommitError(w, req, err)
+		return
+	}
+	if !ok {
+		writeError(w, http.StatusNotFound, "instance not registered: "+req.PathValue("addr"))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
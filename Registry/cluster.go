@@ -0,0 +1,193 @@
+package Registry
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/R-Goys/Orpc/raft"
+)
+
+const proposeTimeout = time.Second * 5
+
+// NewCluster 创建注册中心集群中的一个节点。self 与 peers 为各节点注册中心的完整地址，
+// 例如 http://10.0.0.1:9999/Orpc/registry，peers 需要包含 self。
+// 注册与注销通过 Raft 复制到全部节点，任意节点都可以读，写请求会被重定向到 leader；
+// 心跳续约只记录在 leader 上，由 leader 剔除超时的实例，新的 leader 上任后会给全部实例重新计时。
+// 节点的 Raft 状态只保存在内存中，重启后必须换一个地址重新加入集群，需要以原来的地址重启时使用 OpenCluster
+func NewCluster(timeout time.Duration, self string, peers []string) *ORegistry {
+	r := New(timeout)
+	r.raft = raft.New(r.raftConfig(self, peers))
+	r.startCluster()
+	return r
+}
+
+// OpenCluster 与 NewCluster 相同，但把节点的 Raft 任期、投票与日志持久化到 dir 目录，节点可以以原来的地址重启。
+// 日志定期压缩为注册中心的快照，格式与 Open 的快照相同
+func OpenCluster(timeout time.Duration, dir, self string, peers []string) (*ORegistry, error) {
+	r := New(timeout)
+	n, err := raft.Open(r.raftConfig(self, peers), dir)
+	if err != nil {
+		return nil, err
+	}
+	r.raft = n
+	r.startCluster()
+	return r, nil
+}
+
+func (r *ORegistry) raftConfig(self string, peers []string) raft.Config {
+	return raft.Config{
+		ID:        self,
+		Peers:     peers,
+		Transport: &raft.HTTPTransport{Client: &http.Client{Timeout: time.Second}},
+		Apply: func(data []byte) {
+			var o op
+			if err := json.Unmarshal(data, &o); err != nil {
+				log.Println("Orpc registry: invalid raft entry", err)
+				return
+			}
+			r.mu.Lock()
+			r.apply(o)
+			r.mu.Unlock()
+		},
+		Snapshot: func() ([]byte, error) {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			return json.Marshal(r.takeSnapshot())
+		},
+		Restore: r.restoreSnapshot,
+	}
+}
+
+func (r *ORegistry) startCluster() {
+	r.raft.Start()
+	go r.sweepLoop()
+}
+
+// restoreSnapshot 用 Raft 快照替换全部实例，之前的变更记录不再能推导出增量，换一个 epoch 使 watch 收到全量列表
+func (r *ORegistry) restoreSnapshot(data []byte) {
+	var snap snapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		log.Println("Orpc registry: invalid raft snapshot", err)
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Services = make(map[string]map[string]*ServerItem)
+	now := time.Now()
+	for i := range snap.Instances {
+		item := snap.Instances[i]
+		item.LastHeartbeat = now
+		instances := r.Services[item.Service]
+		if instances == nil {
+			instances = make(map[string]*ServerItem)
+			r.Services[item.Service] = instances
+		}
+		instances[item.Addr] = &item
+	}
+	r.revision = snap.Revision
+	r.epoch = newEpoch()
+	r.events = nil
+	close(r.changed)
+	r.changed = make(chan struct{})
+}
+
+// Leader 返回当前 leader 的地址，单机模式下返回空字符串
+func (r *ORegistry) Leader() string {
+	if r.raft == nil {
+		return ""
+	}
+	return r.raft.Leader()
+}
+
+// IsLeader 单机模式下总是返回 true
+func (r *ORegistry) IsLeader() bool {
+	return r.raft == nil || r.raft.IsLeader()
+}
+
+// redirect 在集群模式下把发到 follower 的写请求重定向到 leader，返回请求是否已经被处理
+func (r *ORegistry) redirect(w http.ResponseWriter, req *http.Request) bool {
+	if r.IsLeader() {
+		return false
+	}
+	leader := r.raft.Leader()
+	if leader == "" {
+		writeRequestError(w, req, http.StatusServiceUnavailable, "no leader")
+		return true
+	}
+	//JSON API 挂载时去掉了注册中心的路径前缀，header 协议则没有
+	path := req.URL.Path
+	if self, err := url.Parse(r.raft.ID()); err == nil {
+		path = strings.TrimPrefix(path, self.Path)
+	}
+	location := leader + path
+	if req.URL.RawQuery != "" {
+		location += "?" + req.URL.RawQuery
+	}
+	w.Header().Set("X-Orpc-Leader", leader)
+	http.Redirect(w, req, location, http.StatusTemporaryRedirect)
+	return true
+}
+
+// writeCommitError 处理修改没能提交的情况，提交过程中失去了 leader 身份时同样重定向到新的 leader
+func (r *ORegistry) writeCommitError(w http.ResponseWriter, req *http.Request, err error) {
+	if errors.Is(err, raft.ErrNotLeader) && r.redirect(w, req) {
+		return
+	}
+	writeRequestError(w, req, http.StatusServiceUnavailable, err.Error())
+}
+
+// writeRequestError JSON API 以及接受 JSON 的请求使用 writeError 的 JSON 响应体，旧的 header 协议返回纯文本
+func writeRequestError(w http.ResponseWriter, req *http.Request, code int, msg string) {
+	if strings.HasPrefix(req.URL.Path, "/v1/") || wantJSON(req) {
+		writeError(w, code, msg)
+		return
+	}
+	http.Error(w, msg, code)
+}
+
+// sweepLoop 集群模式下由 leader 定期剔除心跳超时的实例
+func (r *ORegistry) sweepLoop() {
+	if r.TimeOut == 0 {
+		return
+	}
+	ticker := time.NewTicker(r.TimeOut / 2)
+	defer ticker.Stop()
+	leader := false
+	for {
+		select {
+		case <-r.done:
+			return
+		case <-ticker.C:
+		}
+		if !r.raft.IsLeader() {
+			leader = false
+			continue
+		}
+		var expired []op
+		r.mu.Lock()
+		now := time.Now()
+		for service, instances := range r.Services {
+			for addr, s := range instances {
+				if !leader {
+					//follower 上没有心跳记录，刚成为 leader 时给全部实例一个完整的超时周期
+					s.LastHeartbeat = now
+				} else if s.LastHeartbeat.Add(r.TimeOut).Before(now) {
+					expired = append(expired, op{Op: opDelete, Service: service, Addr: addr})
+				}
+			}
+		}
+		r.mu.Unlock()
+		leader = true
+		for _, o := range expired {
+			if err := r.commit(o); err != nil {
+				log.Println("Orpc registry: expire instance error", err)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package Registry
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/R-Goys/Orpc/codec"
+	Orpc "github.com/R-Goys/Orpc/server"
+)
+
+const defaultHealthFailures = 3
+
+// EnableHealthCheck 让注册中心每隔 interval 通过 Orpc 调用每个实例的 Health.Check 主动探测，
+// 连续 failures 次失败（连接失败、超时或者返回 NOT_SERVING）的实例被标记为 Unhealthy，探测再次成功后恢复。
+// 不健康的实例不会出现在旧协议的地址列表中，JSON 协议中则带有 unhealthy 字段。
+// 没有注册 Health 服务的实例只要能响应请求就视为健康。集群模式下只有 leader 进行探测
+func (r *ORegistry) EnableHealthCheck(interval time.Duration, failures int) {
+	if failures <= 0 {
+		failures = defaultHealthFailures
+	}
+	go r.healthLoop(interval, failures)
+}
+
+type instanceKey struct {
+	service, addr string
+}
+
+func (r *ORegistry) healthLoop(interval time.Duration, threshold int) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	failures := make(map[instanceKey]int)
+	for {
+		select {
+		case <-r.done:
+			return
+		case <-ticker.C:
+		}
+		if !r.IsLeader() {
+			clear(failures)
+			continue
+		}
+		var items []ServerItem
+		r.mu.Lock()
+		for _, instances := range r.Services {
+			for _, s := range instances {
+				items = append(items, *s)
+			}
+		}
+		r.mu.Unlock()
+
+		results := make([]error, len(items))
+		var wg sync.WaitGroup
+		for i := range items {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				results[i] = probe(items[i], interval)
+			}(i)
+		}
+		wg.Wait()
+
+		checked := make(map[instanceKey]int, len(items))
+		for i, item := range items {
+			key := instanceKey{item.Service, item.Addr}
+			if results[i] == nil {
+				if item.Unhealthy {
+					r.setUnhealthy(key, false)
+				}
+				continue
+			}
+			checked[key] = failures[key] + 1
+			if checked[key] == threshold {
+				log.Println("Orpc registry: health check failed", item.Service, item.Addr, results[i])
+				r.setUnhealthy(key, true)
+			}
+		}
+		//已经注销或者恢复的实例不再计数
+		failures = checked
+	}
+}
+
+// setUnhealthy 修改实例的健康状态，实例在探测期间被注销时什么也不做
+func (r *ORegistry) setUnhealthy(key instanceKey, unhealthy bool) {
+	r.mu.Lock()
+	s := r.Services[key.service][key.addr]
+	if s == nil || s.Unhealthy == unhealthy {
+		r.mu.Unlock()
+		return
+	}
+	item := *s
+	item.Unhealthy = unhealthy
+	r.mu.Unlock()
+	if err := r.commit(op{Op: opPut, Item: &item}); err != nil {
+		log.Println("Orpc registry: update health error", err)
+	}
+}
+
+// probe 对实例发起一次健康检查
+func probe(item ServerItem, timeout time.Duration) error {
+	client, err := Orpc.XDial(item.Addr, &Orpc.Option{CodecType: codec.GobType, ConnectTimeOut: timeout, HandleTimeout: timeout})
+	if err != nil {
+		return err
+	}
+	defer func() { _ = client.Close() }()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	status, err := client.CheckHealth(ctx, item.Service)
+	if err != nil {
+		return err
+	}
+	if status == Orpc.StatusNotServing {
+		return errors.New("service " + status.String())
+	}
+	return nil
+}
@@ -0,0 +1,284 @@
+package Registry
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math/rand"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	Orpc "github.com/R-Goys/Orpc/server"
+)
+
+const (
+	heartBeatMinBackoff = 100 * time.Millisecond
+	heartBeatJitter     = 0.2 //每次间隔在 ±20% 内随机，避免大量实例同时发送心跳
+)
+
+// HeartBeatStatus 心跳的健康状况
+type HeartBeatStatus struct {
+	Healthy             bool      //最近一次心跳是否成功
+	LastSuccess         time.Time //最近一次成功的时间
+	LastError           error     //最近一次失败的原因，成功后清空
+	ConsecutiveFailures int
+}
+
+// HeartBeatHandle 一个正在运行的心跳，Stop 停止心跳并从注册中心注销。
+// 心跳失败时不会退出，而是按指数退避重试；每次心跳都会携带完整的注册信息，注册中心重启后会被重新注册
+type HeartBeatHandle struct {
+	registry string
+	addr     string
+	server   *Orpc.Server
+	metadata map[string]string
+	duration time.Duration
+
+	mu       sync.Mutex
+	services []string //最近一次发送的服务列表，注销时使用
+	status   HeartBeatStatus
+	onStatus func(HeartBeatStatus)
+	kick     chan struct{}   //服务列表变化时立即发送一次心跳
+	ctx      context.Context //Stop 时取消，中断正在发送的心跳
+	cancel   context.CancelFunc
+	stop     chan struct{}
+	done     chan struct{}
+	once     sync.Once
+}
+
+// HeartBeat 定期向 registry 发送 addr 的心跳，registry 可以是逗号分隔的多个注册中心地址
+func HeartBeat(registry, addr string, duration time.Duration) *HeartBeatHandle {
+	return startHeartBeat(registry, addr, nil, nil, duration)
+}
+
+// ServerHeartBeat 与 HeartBeat 相同，但每次心跳都会带上 server 当前注册的全部服务以及 metadata，
+// 服务被注册或通过 Unregister 移除时会立即重新发布，被移除的服务会从注册中心注销。server 优雅关闭时会自动停止心跳并注销
+func ServerHeartBeat(registry, addr string, server *Orpc.Server, metadata map[string]string, duration time.Duration) *HeartBeatHandle {
+	h := startHeartBeat(registry, addr, server, metadata, duration)
+	server.OnServicesChange(func([]string) {
+		select {
+		case h.kick <- struct{}{}:
+		default:
+		}
+	})
+	server.RegisterOnShutdown(func(ctx context.Context) {
+		if err := h.Stop(ctx); err != nil {
+			log.Println("Orpc registry: deregister on shutdown error", err)
+		}
+	})
+	return h
+}
+
+func startHeartBeat(registry, addr string, server *Orpc.Server, metadata map[string]string, duration time.Duration) *HeartBeatHandle {
+	if duration == 0 {
+		duration = defaultTimeout - time.Second
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	h := &HeartBeatHandle{
+		ctx:      ctx,
+		cancel:   cancel,
+		registry: registry,
+		addr:     addr,
+		server:   server,
+		metadata: metadata,
+		duration: duration,
+		kick:     make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	h.send()
+	go h.loop()
+	return h
+}
+
+func (h *HeartBeatHandle) loop() {
+	defer close(h.done)
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+	for {
+		t := time.NewTimer(h.next(r))
+		select {
+		case <-h.stop:
+			t.Stop()
+			return
+		case <-t.C:
+			h.send()
+		case <-h.kick:
+			t.Stop()
+			h.send()
+		}
+	}
+}
+
+// next 返回距离下一次心跳的时间：成功时为 duration，失败时从 heartBeatMinBackoff 开始指数退避，最多为 duration，均带有抖动
+func (h *HeartBeatHandle) next(r *rand.Rand) time.Duration {
+	h.mu.Lock()
+	failures := h.status.ConsecutiveFailures
+	h.mu.Unlock()
+	d := h.duration
+	if failures > 0 {
+		backoff := heartBeatMinBackoff
+		for i := 1; i < failures && backoff < d; i++ {
+			backoff *= 2
+		}
+		if backoff < d {
+			d = backoff
+		}
+	}
+	jitter := time.Duration(float64(d) * heartBeatJitter)
+	if jitter <= 0 {
+		return d
+	}
+	return d - jitter + time.Duration(r.Int63n(int64(2*jitter)))
+}
+
+func (h *HeartBeatHandle) send() {
+	var services []string
+	if h.server != nil {
+		services = h.server.Services()
+	}
+	err := sendHeartBeat(h.ctx, h.registry, h.addr, services, h.metadata)
+	if h.ctx.Err() != nil {
+		//已经 Stop，被中断的心跳不计入状态
+		return
+	}
+	h.mu.Lock()
+	removed := removedServices(h.services, services)
+	h.services = services
+	healthy := h.status.Healthy
+	if err == nil {
+		h.status = HeartBeatStatus{Healthy: true, LastSuccess: time.Now()}
+	} else {
+		h.status.Healthy = false
+		h.status.LastError = err
+		h.status.ConsecutiveFailures++
+	}
+	status, onStatus := h.status, h.onStatus
+	h.mu.Unlock()
+	if len(removed) > 0 {
+		ctx, cancel := context.WithTimeout(h.ctx, defaultTimeout)
+		if err := sendDeregister(ctx, h.registry, h.addr, removed); err != nil {
+			log.Println("Orpc registry: deregister removed services error", err)
+		}
+		cancel()
+	}
+	if onStatus != nil && healthy != status.Healthy {
+		onStatus(status)
+	}
+}
+
+// removedServices 返回 prev 中有而 cur 中没有的服务
+func removedServices(prev, cur []string) []string {
+	var removed []string
+	for _, s := range prev {
+		if !slices.Contains(cur, s) {
+			removed = append(removed, s)
+		}
+	}
+	return removed
+}
+
+// Status 返回心跳当前的健康状况
+func (h *HeartBeatHandle) Status() HeartBeatStatus {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.status
+}
+
+// OnStatusChange 设置心跳在健康与不健康之间切换时的回调，回调在心跳的 goroutine 中执行
+func (h *HeartBeatHandle) OnStatusChange(f func(HeartBeatStatus)) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.onStatus = f
+}
+
+// Stop 停止心跳，并向注册中心发送注销请求使实例立即下线，多次调用只有第一次会注销。
+// 正在发送的心跳会被中断，ctx 结束时不再等待并返回 ctx.Err()
+func (h *HeartBeatHandle) Stop(ctx context.Context) error {
+	var err error
+	h.once.Do(func() {
+		close(h.stop)
+		h.cancel()
+		select {
+		case <-h.done:
+		case <-ctx.Done():
+			err = ctx.Err()
+			return
+		}
+		h.mu.Lock()
+		services := h.services
+		h.mu.Unlock()
+		err = sendDeregister(ctx, h.registry, h.addr, services)
+	})
+	return err
+}
+
+// sendHeartBeat registry 可以是逗号分隔的多个注册中心地址，依次尝试直到有一个成功
+func sendHeartBeat(ctx context.Context, registry, addr string, services []string, metadata map[string]string) (err error) {
+	for _, r := range strings.Split(registry, ",") {
+		if err = sendHeartBeatTo(ctx, r, addr, services, metadata); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+func sendHeartBeatTo(ctx context.Context, registry, addr string, services []string, metadata map[string]string) error {
+	log.Println("SendHeartBeat", registry, addr)
+	httpClient := &http.Client{Timeout: defaultTimeout}
+	req, err := http.NewRequestWithContext(ctx, "POST", registry, nil)
+	if err != nil {
+		log.Println("sendHeartBeat err:", err)
+		return err
+	}
+	req.Header.Set("X-Orpc-Server", addr)
+	if len(services) > 0 {
+		req.Header.Set("X-Orpc-Services", strings.Join(services, ","))
+	}
+	if len(metadata) > 0 {
+		req.Header.Set("X-Orpc-Metadata", formatMetadata(metadata))
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		log.Println("sendHeartBeat err:", err)
+		return err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		err = errors.New("unexpected registry response: " + resp.Status)
+		log.Println("sendHeartBeat err:", err)
+		return err
+	}
+	return nil
+}
+
+func sendDeregister(ctx context.Context, registry, addr string, services []string) (err error) {
+	for _, r := range strings.Split(registry, ",") {
+		if err = sendDeregisterTo(ctx, r, addr, services); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+func sendDeregisterTo(ctx context.Context, registry, addr string, services []string) error {
+	log.Println("SendDeregister", registry, addr)
+	req, err := http.NewRequestWithContext(ctx, "DELETE", registry, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Orpc-Server", addr)
+	if len(services) > 0 {
+		req.Header.Set("X-Orpc-Services", strings.Join(services, ","))
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return errors.New("unexpected registry response: " + resp.Status)
+	}
+	return nil
+}
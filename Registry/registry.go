@@ -1,121 +1,700 @@
 package Registry
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
 	"log"
 	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/R-Goys/Orpc/raft"
 )
 
 type ORegistry struct {
-	TimeOut time.Duration
-	mu      sync.Mutex
-	Server  map[string]*ServerItem
+	TimeOut  time.Duration
+	mu       sync.Mutex
+	Services map[string]map[string]*ServerItem //服务名 -> 地址 -> 实例，旧协议注册的实例服务名为空
+	revision uint64                            //每次服务列表变化都会自增
+	epoch    string                            //每个进程随机生成，重启或者从快照恢复后改变，版本号只在同一个 epoch 内可以比较
+	events   []Event                           //最近的变更记录，用于给 watch 返回增量
+	changed  chan struct{}                     //服务列表变化时关闭，用于唤醒 watch
+	store    *store                            //为 nil 时不持久化，见 Open
+	raft     *raft.Node                        //为 nil 时为单机模式，见 NewCluster
+	done     chan struct{}                     //Close 时关闭，用于停止后台的 goroutine
+	once     sync.Once
 }
 
 type ServerItem struct {
-	Addr  string
-	start time.Time
+	Service       string            `json:"service"`
+	Addr          string            `json:"addr"`
+	Weight        int               `json:"weight,omitempty"`
+	Zone          string            `json:"zone,omitempty"`
+	Version       string            `json:"version,omitempty"`
+	Tags          []string          `json:"tags,omitempty"`
+	Metadata      map[string]string `json:"metadata,omitempty"` //除上述字段外的其他元数据
+	LastHeartbeat time.Time         `json:"lastHeartbeat"`
+	Unverified    bool              `json:"unverified,omitempty"` //从磁盘恢复、重启后还没有收到过心跳
+	Unhealthy     bool              `json:"unhealthy,omitempty"`  //连续多次没有通过健康检查，见 EnableHealthCheck
+	Drained       bool              `json:"drained,omitempty"`    //被管理员摘除流量，心跳照常但不再被路由，见 Drain
+}
+
+// routable 实例是否应该被路由
+func (s ServerItem) routable() bool {
+	return !s.Unhealthy && !s.Drained
 }
 
+// 心跳中携带的元数据里有特殊含义的 key，tags 以逗号分隔
 const (
-	defaultPath    = "/Orpc/registry"
-	defaultTimeout = time.Second * 5
+	MetaWeight  = "weight"
+	MetaZone    = "zone"
+	MetaVersion = "version"
+	MetaTags    = "tags"
+)
+
+// newServerItem 把元数据中的已知字段解析到 ServerItem 上，其余的保存在 Metadata 中
+func newServerItem(service, addr string, metadata map[string]string) ServerItem {
+	item := ServerItem{Service: service, Addr: addr}
+	for k, v := range metadata {
+		switch k {
+		case MetaWeight:
+			item.Weight, _ = strconv.Atoi(v)
+		case MetaZone:
+			item.Zone = v
+		case MetaVersion:
+			item.Version = v
+		case MetaTags:
+			item.Tags = splitList(v)
+		default:
+			if item.Metadata == nil {
+				item.Metadata = make(map[string]string)
+			}
+			item.Metadata[k] = v
+		}
+	}
+	return item
+}
+
+// sameMetadata 比较两个实例除心跳时间外是否相同
+func sameMetadata(a, b ServerItem) bool {
+	a.LastHeartbeat, b.LastHeartbeat = time.Time{}, time.Time{}
+	return reflect.DeepEqual(a, b)
+}
+
+type EventType int
+
+const (
+	EventPut EventType = iota
+	EventDelete
+)
+
+// Event 一次服务列表的变更，Revision 为变更之后注册中心的版本号
+type Event struct {
+	Revision uint64
+	Type     EventType
+	Service  string
+	Addr     string
+}
+
+const (
+	defaultPath         = "/Orpc/registry"
+	defaultTimeout      = time.Second * 5
+	defaultWatchTimeout = time.Second * 30
+	maxEvents           = 1024
 )
 
 func New(timeout time.Duration) *ORegistry {
 	return &ORegistry{
-		TimeOut: timeout,
-		Server:  make(map[string]*ServerItem),
+		TimeOut:  timeout,
+		Services: make(map[string]map[string]*ServerItem),
+		changed:  make(chan struct{}),
+		done:     make(chan struct{}),
+		epoch:    newEpoch(),
 	}
 }
 
+func newEpoch() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Epoch 返回注册中心当前的 epoch，watch 时携带的 epoch 与它不同说明注册中心重启过，会直接得到全量列表
+func (r *ORegistry) Epoch() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.epoch
+}
+
 var DefaultORegister = New(defaultTimeout)
 
+// PutServer 以旧协议注册一个不属于任何服务的地址
 func (r *ORegistry) PutServer(addr string) {
+	if err := r.PutService("", addr, nil); err != nil {
+		log.Println("Orpc registry: put server error", err)
+	}
+}
+
+// PutService 注册或续约 service 在 addr 上的实例，metadata 为 nil 时保留原有的元数据
+func (r *ORegistry) PutService(service, addr string, metadata map[string]string) error {
+	if metadata == nil {
+		if ok, err := r.Heartbeat(service, addr); ok || err != nil {
+			return err
+		}
+	}
+	_, err := r.Register(newServerItem(service, addr, metadata))
+	return err
+}
+
+// Register 注册或续约 item 描述的实例，元数据以 item 为准，返回是否为新实例
+func (r *ORegistry) Register(item ServerItem) (bool, error) {
 	r.mu.Lock()
-	defer r.mu.Unlock()
-	s := r.Server[addr]
+	s := r.Services[item.Service][item.Addr]
+	if s != nil {
+		//健康与摘除状态由注册中心自己维护
+		item.Unhealthy, item.Drained = s.Unhealthy, s.Drained
+	}
+	if s != nil && sameMetadata(*s, item) {
+		s.LastHeartbeat = time.Now()
+		r.mu.Unlock()
+		return false, nil
+	}
+	r.mu.Unlock()
+	return s == nil, r.commit(op{Op: opPut, Item: &item})
+}
+
+// Heartbeat 续约一个已经注册的实例，实例不存在时返回 false
+func (r *ORegistry) Heartbeat(service, addr string) (bool, error) {
+	r.mu.Lock()
+	s := r.Services[service][addr]
 	if s == nil {
-		r.Server[addr] = &ServerItem{
-			Addr:  addr,
-			start: time.Now(),
+		r.mu.Unlock()
+		return false, nil
+	}
+	s.LastHeartbeat = time.Now()
+	if !s.Unverified {
+		r.mu.Unlock()
+		return true, nil
+	}
+	item := *s
+	item.Unverified = false
+	r.mu.Unlock()
+	return true, r.commit(op{Op: opPut, Item: &item})
+}
+
+// Drain 摘除或恢复一个实例的流量，摘除后实例仍然保留在注册中心中，实例不存在时返回 false
+func (r *ORegistry) Drain(service, addr string, drained bool) (bool, error) {
+	r.mu.Lock()
+	s := r.Services[service][addr]
+	if s == nil || s.Drained == drained {
+		r.mu.Unlock()
+		return s != nil, nil
+	}
+	item := *s
+	item.Drained = drained
+	r.mu.Unlock()
+	return true, r.commit(op{Op: opPut, Item: &item})
+}
+
+// Deregister 立即移除一个实例，实例不存在时返回 false
+func (r *ORegistry) Deregister(service, addr string) (bool, error) {
+	r.mu.Lock()
+	s := r.Services[service][addr]
+	r.mu.Unlock()
+	if s == nil {
+		return false, nil
+	}
+	return true, r.commit(op{Op: opDelete, Service: service, Addr: addr})
+}
+
+const (
+	opPut    = "put"
+	opDelete = "delete"
+)
+
+// op 一次对实例列表的修改，集群模式下会先通过 Raft 复制到多数节点再应用
+type op struct {
+	Op      string      `json:"op"`
+	Item    *ServerItem `json:"item,omitempty"`
+	Service string      `json:"service,omitempty"`
+	Addr    string      `json:"addr,omitempty"`
+}
+
+func (r *ORegistry) commit(o op) error {
+	if r.raft == nil {
+		r.mu.Lock()
+		r.apply(o)
+		r.mu.Unlock()
+		return nil
+	}
+	data, err := json.Marshal(o)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), proposeTimeout)
+	defer cancel()
+	return r.raft.Propose(ctx, data)
+}
+
+// apply 应用一次修改，调用方需要持有 r.mu
+func (r *ORegistry) apply(o op) {
+	switch o.Op {
+	case opPut:
+		item := *o.Item
+		item.LastHeartbeat = time.Now()
+		instances := r.Services[item.Service]
+		if instances == nil {
+			instances = make(map[string]*ServerItem)
+			r.Services[item.Service] = instances
 		}
-	} else {
-		s.start = time.Now()
+		if s := instances[item.Addr]; s != nil {
+			*s = item
+		} else {
+			instances[item.Addr] = &item
+		}
+		r.record(EventPut, item.Service, item.Addr)
+	case opDelete:
+		instances := r.Services[o.Service]
+		if instances[o.Addr] == nil {
+			return
+		}
+		delete(instances, o.Addr)
+		if len(instances) == 0 {
+			delete(r.Services, o.Service)
+		}
+		r.record(EventDelete, o.Service, o.Addr)
 	}
 }
 
-func (r *ORegistry) aliveServers() []string {
+// record 记录一次变更并唤醒所有 watch，调用方需要持有 r.mu
+func (r *ORegistry) record(typ EventType, service, addr string) {
+	r.revision++
+	r.events = append(r.events, Event{Revision: r.revision, Type: typ, Service: service, Addr: addr})
+	if len(r.events) > maxEvents {
+		r.events = append([]Event(nil), r.events[len(r.events)-maxEvents:]...)
+	}
+	close(r.changed)
+	r.changed = make(chan struct{})
+	if r.store != nil {
+		e := walEntry{Revision: r.revision, Service: service, Addr: addr, Op: "delete"}
+		if typ == EventPut {
+			item := *r.Services[service][addr]
+			e = walEntry{Revision: r.revision, Op: "put", Item: &item}
+		}
+		r.store.append(r, e)
+	}
+}
+
+// restore 放入一个从磁盘加载的实例，它在 TimeOut 内需要重新发送心跳，调用方需要持有 r.mu
+func (r *ORegistry) restore(item ServerItem) {
+	item.LastHeartbeat = time.Now()
+	item.Unverified = true
+	instances := r.Services[item.Service]
+	if instances == nil {
+		instances = make(map[string]*ServerItem)
+		r.Services[item.Service] = instances
+	}
+	instances[item.Addr] = &item
+}
+
+// Close 停止后台的 goroutine 与集群节点，在启用了持久化时写入最后一次快照并关闭文件
+func (r *ORegistry) Close() error {
+	r.once.Do(func() {
+		close(r.done)
+		if r.raft != nil {
+			r.raft.Stop()
+		}
+	})
 	r.mu.Lock()
 	defer r.mu.Unlock()
-	var alive []string
-	for addr, s := range r.Server {
-		if s.start.Add(r.TimeOut).After(time.Now()) || r.TimeOut == 0 {
-			alive = append(alive, addr)
-		} else {
-			delete(r.Server, addr)
+	if r.store == nil {
+		return nil
+	}
+	err := r.store.close(r)
+	r.store = nil
+	return err
+}
+
+// expire 删除心跳超时的实例，集群模式下由 leader 的 sweepLoop 负责，调用方需要持有 r.mu
+func (r *ORegistry) expire() {
+	if r.TimeOut == 0 || r.raft != nil {
+		return
+	}
+	for service, instances := range r.Services {
+		for addr, s := range instances {
+			if !s.LastHeartbeat.Add(r.TimeOut).After(time.Now()) {
+				delete(instances, addr)
+				r.record(EventDelete, service, addr)
+			}
+		}
+		if len(instances) == 0 {
+			delete(r.Services, service)
 		}
 	}
-	return alive
 }
 
+// servers 返回 service 的全部可以路由的地址，service 为空时返回所有服务去重后的地址，调用方需要持有 r.mu
+func (r *ORegistry) servers(service string) []string {
+	seen := make(map[string]bool)
+	for name, instances := range r.Services {
+		if service != "" && name != service {
+			continue
+		}
+		for addr, s := range instances {
+			if s.routable() {
+				seen[addr] = true
+			}
+		}
+	}
+	servers := make([]string, 0, len(seen))
+	for addr := range seen {
+		servers = append(servers, addr)
+	}
+	sort.Strings(servers)
+	return servers
+}
+
+// instances 返回 service 的全部实例的拷贝，service 为空时同一地址只保留一个实例，调用方需要持有 r.mu
+func (r *ORegistry) instances(service string) []ServerItem {
+	var names []string
+	for name := range r.Services {
+		if service == "" || name == service {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	seen := make(map[string]bool)
+	items := make([]ServerItem, 0)
+	for _, name := range names {
+		for addr, s := range r.Services[name] {
+			if seen[addr] {
+				continue
+			}
+			seen[addr] = true
+			items = append(items, *s)
+		}
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Addr < items[j].Addr })
+	return items
+}
+
+func (r *ORegistry) aliveServers(service string) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.expire()
+	return r.servers(service)
+}
+
+// Revision 返回注册中心当前的版本号
+func (r *ORegistry) Revision() uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.revision
+}
+
+// Watch 阻塞直到 service 的实例在 revision 之后发生变化、超时或 ctx 结束，返回当前版本号以及这期间 service 的变更。
+// service 为空时关注所有服务，此时或者变更记录已经不足以推导出增量（太旧或者版本号比当前的还新）时，full 为 true，
+// 调用方应改为拉取全量列表。注册中心重启后版本号可能重新增长到与重启前相同，revision 只有在 Epoch 不变时才有意义，
+// HTTP 协议中由 watchDelta 比较客户端携带的 epoch
+func (r *ORegistry) Watch(ctx context.Context, service string, revision uint64, timeout time.Duration) (rev uint64, events []Event, full bool) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	var sweep <-chan time.Time
+	if r.TimeOut > 0 {
+		t := time.NewTicker(r.TimeOut)
+		defer t.Stop()
+		sweep = t.C
+	}
+	rev = revision
+	for {
+		r.mu.Lock()
+		r.expire()
+		if r.revision != rev {
+			all := r.eventsSince(rev)
+			if all == nil || service == "" {
+				rev = r.revision
+				r.mu.Unlock()
+				return rev, nil, true
+			}
+			for _, e := range all {
+				if e.Service == service {
+					events = append(events, e)
+				}
+			}
+			rev = r.revision
+			if len(events) > 0 {
+				r.mu.Unlock()
+				return rev, events, false
+			}
+		}
+		changed := r.changed
+		r.mu.Unlock()
+		select {
+		case <-changed:
+		case <-sweep:
+		case <-timer.C:
+			return rev, nil, false
+		case <-ctx.Done():
+			return rev, nil, false
+		}
+	}
+}
+
+// eventsSince 返回 revision 之后的全部变更，无法得到完整增量时返回 nil，调用方需要持有 r.mu
+func (r *ORegistry) eventsSince(revision uint64) []Event {
+	if revision > r.revision || len(r.events) == 0 || r.events[0].Revision > revision+1 {
+		return nil
+	}
+	for i, e := range r.events {
+		if e.Revision > revision {
+			return append([]Event(nil), r.events[i:]...)
+		}
+	}
+	return nil
+}
+
+// ServeHTTP 旧的基于 header 的协议：X-Orpc-Service 用于按服务名过滤，POST/DELETE 时 X-Orpc-Services 携带该地址提供的全部服务，
+// X-Orpc-Metadata 以 URL query 的形式携带元数据。GET 时请求头 Accept: application/json 会得到带元数据的 JSON 响应
 func (r *ORegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	switch req.Method {
 	case "GET":
-		w.Header().Set("X-Orpc-Servers", strings.Join(r.aliveServers(), ","))
+		if req.Header.Get("X-Orpc-Watch") != "" {
+			r.serveWatch(w, req)
+			return
+		}
+		service := req.Header.Get("X-Orpc-Service")
+		r.mu.Lock()
+		r.expire()
+		rev := r.revision
+		if wantJSON(req) {
+			resp := watchResponse{Epoch: r.epoch, Revision: rev, Full: true, Instances: r.instances(service)}
+			r.mu.Unlock()
+			writeJSON(w, resp)
+			return
+		}
+		servers := r.servers(service)
+		epoch := r.epoch
+		r.mu.Unlock()
+		w.Header().Set("X-Orpc-Servers", strings.Join(servers, ","))
+		w.Header().Set("X-Orpc-Revision", strconv.FormatUint(rev, 10))
+		w.Header().Set("X-Orpc-Epoch", epoch)
 	case "POST":
 		addr := req.Header.Get("X-Orpc-Server")
 		if addr == "" {
 			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
-		r.PutServer(addr)
+		metadata, err := parseMetadata(req.Header.Get("X-Orpc-Metadata"))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if r.redirect(w, req) {
+			return
+		}
+		services := splitList(req.Header.Get("X-Orpc-Services"))
+		if len(services) == 0 {
+			services = []string{""}
+		}
+		for _, service := range services {
+			if err = r.PutService(service, addr, metadata); err != nil {
+				r.writeCommitError(w, req, err)
+				return
+			}
+		}
+	case "DELETE":
+		addr := req.Header.Get("X-Orpc-Server")
+		if addr == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if r.redirect(w, req) {
+			return
+		}
+		services := splitList(req.Header.Get("X-Orpc-Services"))
+		if len(services) == 0 {
+			services = []string{""}
+		}
+		found := false
+		for _, service := range services {
+			ok, err := r.Deregister(service, addr)
+			if err != nil {
+				r.writeCommitError(w, req, err)
+				return
+			}
+			found = ok || found
+		}
+		if !found {
+			w.WriteHeader(http.StatusNotFound)
+		}
 	default:
 		w.WriteHeader(http.StatusMethodNotAllowed)
 	}
 }
 
-func (r *ORegistry) HandleHTTP(registryPath string) {
-	http.Handle(registryPath, r)
-	log.Println("Register HTTP handler", registryPath)
+// watchResponse JSON 协议的响应，Full 为 true 时 Instances 为全量列表，否则 Added/Removed 为增量
+type watchResponse struct {
+	Epoch     string       `json:"epoch"`
+	Revision  uint64       `json:"revision"`
+	Full      bool         `json:"full"`
+	Instances []ServerItem `json:"instances,omitempty"`
+	Added     []ServerItem `json:"added,omitempty"`
+	Removed   []string     `json:"removed,omitempty"`
 }
 
-func HandleHTTP() {
-	DefaultORegister.HandleHTTP(defaultPath)
+func wantJSON(req *http.Request) bool {
+	return strings.Contains(req.Header.Get("Accept"), "application/json")
 }
 
-func HeartBeat(registry, addr string, duration time.Duration) {
-	if duration == 0 {
-		duration = defaultTimeout - time.Second
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Println("Orpc registry: write response error", err)
 	}
-	var err error
-	err = sendHeartBeat(registry, addr)
+}
 
-	go func() {
-		t := time.NewTicker(duration)
-		defer t.Stop()
-		for err == nil {
-			<-t.C
-			err = sendHeartBeat(registry, addr)
+// serveWatch 长轮询：X-Orpc-Watch 携带客户端已知的版本号，X-Orpc-Epoch 携带该版本号所属的 epoch，X-Orpc-Watch-Timeout 为可选的等待时长，
+// 有变更时通过 X-Orpc-Added/X-Orpc-Removed 返回增量，无法给出增量（包括 epoch 不同）时通过 X-Orpc-Servers 返回全量
+func (r *ORegistry) serveWatch(w http.ResponseWriter, req *http.Request) {
+	revision, err := strconv.ParseUint(req.Header.Get("X-Orpc-Watch"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	timeout := defaultWatchTimeout
+	if t := req.Header.Get("X-Orpc-Watch-Timeout"); t != "" {
+		if timeout, err = time.ParseDuration(t); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
 		}
-	}()
+	}
+	resp := r.watchDelta(req, req.Header.Get("X-Orpc-Service"), req.Header.Get("X-Orpc-Epoch"), revision, timeout)
+	if wantJSON(req) {
+		writeJSON(w, resp)
+		return
+	}
+	//旧协议没有健康状态，不健康或者被摘除的实例当作已经下线
+	if resp.Full {
+		servers := make([]string, 0, len(resp.Instances))
+		for _, s := range resp.Instances {
+			if s.routable() {
+				servers = append(servers, s.Addr)
+			}
+		}
+		w.Header().Set("X-Orpc-Servers", strings.Join(servers, ","))
+	} else {
+		added := make([]string, 0, len(resp.Added))
+		for _, s := range resp.Added {
+			if !s.routable() {
+				resp.Removed = append(resp.Removed, s.Addr)
+			} else {
+				added = append(added, s.Addr)
+			}
+		}
+		w.Header().Set("X-Orpc-Added", strings.Join(added, ","))
+		w.Header().Set("X-Orpc-Removed", strings.Join(resp.Removed, ","))
+	}
+	w.Header().Set("X-Orpc-Revision", strconv.FormatUint(resp.Revision, 10))
+	w.Header().Set("X-Orpc-Epoch", resp.Epoch)
+}
 
+// watchDelta 等待 service 在 revision 之后的变更，并整理成响应：同一个地址变更多次时以当前的状态为准。
+// epoch 不为空且与当前的不同时，客户端的 revision 来自重启之前，直接返回全量
+func (r *ORegistry) watchDelta(req *http.Request, service, epoch string, revision uint64, timeout time.Duration) watchResponse {
+	var (
+		rev    uint64
+		events []Event
+		full   = epoch != "" && epoch != r.Epoch()
+	)
+	if !full {
+		rev, events, full = r.Watch(req.Context(), service, revision, timeout)
+	}
+	resp := watchResponse{Revision: rev, Full: full}
+	r.mu.Lock()
+	if resp.Epoch = r.epoch; resp.Epoch != epoch && epoch != "" {
+		//等待期间从快照恢复过
+		resp.Full = true
+	}
+	if resp.Full {
+		resp.Revision = r.revision
+		resp.Instances = r.instances(service)
+	} else {
+		seen := make(map[string]bool)
+		for _, e := range events {
+			if seen[e.Addr] {
+				continue
+			}
+			seen[e.Addr] = true
+			if s := r.Services[service][e.Addr]; s != nil {
+				resp.Added = append(resp.Added, *s)
+			} else {
+				resp.Removed = append(resp.Removed, e.Addr)
+			}
+		}
+	}
+	r.mu.Unlock()
+	return resp
 }
 
-func sendHeartBeat(registry, addr string) error {
-	log.Println("SendHeartBeat", registry, addr)
-	httpClient := &http.Client{}
-	req, err := http.NewRequest("POST", registry, nil)
+func splitList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+func parseMetadata(s string) (map[string]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+	values, err := url.ParseQuery(s)
 	if err != nil {
-		log.Println("sendHeartBeat err:", err)
-		return err
+		return nil, err
 	}
-	req.Header.Set("X-Orpc-Server", addr)
-	if _, err = httpClient.Do(req); err != nil {
-		log.Println("sendHeartBeat err:", err)
-		return err
+	metadata := make(map[string]string, len(values))
+	for k, v := range values {
+		metadata[k] = strings.Join(v, ",")
 	}
-	return nil
+	return metadata, nil
+}
+
+func formatMetadata(metadata map[string]string) string {
+	values := make(url.Values, len(metadata))
+	for k, v := range metadata {
+		values.Set(k, v)
+	}
+	return values.Encode()
+}
+
+// Handler 返回挂载在 registryPath 下的全部 handler：registryPath 上为旧的 header 协议，registryPath/v1/ 下为 JSON API，
+// 集群模式下 registryPath/raft/ 下为节点之间的 Raft 通信
+func (r *ORegistry) Handler(registryPath string) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle(registryPath, r)
+	mux.Handle(registryPath+"/v1/", http.StripPrefix(registryPath, r.APIHandler()))
+	if r.raft != nil {
+		mux.Handle(registryPath+"/raft/", r.raft)
+	}
+	return mux
+}
+
+func (r *ORegistry) HandleHTTP(registryPath string) {
+	h := r.Handler(registryPath)
+	http.Handle(registryPath, h)
+	http.Handle(registryPath+"/", h)
+	log.Println("Register HTTP handler", registryPath)
+}
+
+func HandleHTTP() {
+	DefaultORegister.HandleHTTP(defaultPath)
 }
@@ -0,0 +1,184 @@
+package Registry
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	snapshotFile          = "snapshot.json"
+	walFile               = "wal.log"
+	defaultSnapshotPeriod = 1000 //每追加这么多条日志做一次快照
+)
+
+// walEntry 预写日志中的一条记录，Op 为 put 时 Item 为变更后的实例
+type walEntry struct {
+	Revision uint64      `json:"revision"`
+	Op       string      `json:"op"`
+	Item     *ServerItem `json:"item,omitempty"`
+	Service  string      `json:"service,omitempty"`
+	Addr     string      `json:"addr,omitempty"`
+}
+
+type snapshot struct {
+	Revision  uint64       `json:"revision"`
+	Instances []ServerItem `json:"instances"`
+}
+
+// store 注册中心的磁盘存储：只追加的变更日志加上定期的全量快照，快照完成后日志被清空
+type store struct {
+	dir     string
+	wal     *os.File
+	entries int
+}
+
+// Open 创建一个把变更持久化到 dir 目录的注册中心。dir 中已有的数据会被加载，
+// 加载出来的实例标记为 Unverified，直到它们再次发送心跳；在 timeout 内没有心跳的实例照常被剔除
+func Open(timeout time.Duration, dir string) (*ORegistry, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	r := New(timeout)
+	s := &store{dir: dir}
+	if err := s.load(r); err != nil {
+		return nil, err
+	}
+	//把加载的结果写成新的快照，旧的日志随之清空
+	if err := s.snapshot(r); err != nil {
+		return nil, err
+	}
+	r.store = s
+	return r, nil
+}
+
+// load 读取快照并重放日志，调用时 r 还没有被其他 goroutine 使用
+func (s *store) load(r *ORegistry) error {
+	data, err := os.ReadFile(filepath.Join(s.dir, snapshotFile))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err == nil {
+		var snap snapshot
+		if err = json.Unmarshal(data, &snap); err != nil {
+			return err
+		}
+		r.revision = snap.Revision
+		for i := range snap.Instances {
+			r.restore(snap.Instances[i])
+		}
+	}
+	f, err := os.Open(filepath.Join(s.dir, walFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	dec := json.NewDecoder(bufio.NewReader(f))
+	for {
+		var e walEntry
+		if err = dec.Decode(&e); err != nil {
+			if err != io.EOF {
+				//最后一条记录可能只写了一半，丢弃它之后的内容
+				log.Println("Orpc registry: truncated wal", err)
+			}
+			return nil
+		}
+		if e.Revision <= r.revision {
+			continue
+		}
+		r.revision = e.Revision
+		switch e.Op {
+		case "put":
+			if e.Item != nil {
+				r.restore(*e.Item)
+			}
+		case "delete":
+			delete(r.Services[e.Service], e.Addr)
+			if len(r.Services[e.Service]) == 0 {
+				delete(r.Services, e.Service)
+			}
+		}
+	}
+}
+
+// snapshot 把 r 的全部实例原子地写入快照文件，并清空日志，调用方需要持有 r.mu 或者 r 还未被使用
+func (s *store) snapshot(r *ORegistry) error {
+	data, err := json.Marshal(r.takeSnapshot())
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(s.dir, snapshotFile+".tmp")
+	if err = writeFileSync(tmp, data); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp, filepath.Join(s.dir, snapshotFile)); err != nil {
+		return err
+	}
+	if s.wal != nil {
+		_ = s.wal.Close()
+	}
+	s.wal, err = os.OpenFile(filepath.Join(s.dir, walFile), os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o644)
+	s.entries = 0
+	return err
+}
+
+// takeSnapshot 返回 r 的版本号与全部实例，调用方需要持有 r.mu
+func (r *ORegistry) takeSnapshot() snapshot {
+	snap := snapshot{Revision: r.revision, Instances: make([]ServerItem, 0)}
+	for _, instances := range r.Services {
+		for _, item := range instances {
+			snap.Instances = append(snap.Instances, *item)
+		}
+	}
+	return snap
+}
+
+func writeFileSync(name string, data []byte) error {
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(data); err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// append 追加一条变更日志，日志足够多时做一次快照，调用方需要持有 r.mu
+func (s *store) append(r *ORegistry, e walEntry) {
+	data, err := json.Marshal(e)
+	if err == nil {
+		_, err = s.wal.Write(append(data, '\n'))
+	}
+	if err != nil {
+		log.Println("Orpc registry: write wal error", err)
+		return
+	}
+	if s.entries++; s.entries >= defaultSnapshotPeriod {
+		if err = s.snapshot(r); err != nil {
+			log.Println("Orpc registry: snapshot error", err)
+		}
+	}
+}
+
+func (s *store) close(r *ORegistry) error {
+	err := s.snapshot(r)
+	if s.wal != nil {
+		if closeErr := s.wal.Close(); err == nil {
+			err = closeErr
+		}
+		s.wal = nil
+	}
+	return err
+}
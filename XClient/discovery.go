@@ -1,10 +1,14 @@
 package XClient
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"log"
 	"math/rand"
 	"net/http"
+	"reflect"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -15,55 +19,182 @@ type SelectMode int
 const (
 	RandomSelect SelectMode = iota
 	RoundRobinSelect
+	WeightedRandomSelect //按实例的 Weight 随机选择
+)
+
+const (
 	DefaultUpdateTimeout = 5 * time.Second
+	defaultWatchTimeout  = 30 * time.Second
+	watchRetryInterval   = time.Second
 )
 
 type Discovery interface {
 	Refresh() error
-	Update(Servers []string) error
-	Get(Mode SelectMode) (string, error)
-	GetAll() ([]string, error)
+	Update(Servers []ServiceInstance) error
+	Get(Mode SelectMode) (ServiceInstance, error)
+	GetAll() ([]ServiceInstance, error)
+	// Watch 返回一个接收服务列表变更的 channel，Discovery 关闭时 channel 也会被关闭
+	Watch() <-chan Event
+}
+
+type EventType int
+
+const (
+	ServerAdded EventType = iota
+	ServerRemoved
+	ServerUpdated //地址不变，元数据发生变化
+)
+
+// Event 服务列表中某个地址的变更，ServerRemoved 时 Instance 为移除前的实例
+type Event struct {
+	Type     EventType
+	Addr     string
+	Instance ServiceInstance
+}
+
+// watchers 管理 Watch 的订阅者，发送时不阻塞，订阅者处理不过来时丢弃事件
+type watchers struct {
+	mu     sync.Mutex
+	subs   []chan Event
+	closed bool
+}
+
+func (w *watchers) Watch() <-chan Event {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	ch := make(chan Event, 64)
+	if w.closed {
+		close(ch)
+		return ch
+	}
+	w.subs = append(w.subs, ch)
+	return ch
+}
+
+func (w *watchers) publish(events ...Event) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, ch := range w.subs {
+		for _, e := range events {
+			select {
+			case ch <- e:
+			default:
+				log.Println("Orpc discovery: watcher is full, drop event", e.Addr)
+			}
+		}
+	}
+}
+
+func (w *watchers) close() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return
+	}
+	w.closed = true
+	for _, ch := range w.subs {
+		close(ch)
+	}
+	w.subs = nil
 }
 
 type MultiServerDiscovery struct {
-	servers []string
-	r       *rand.Rand
-	mu      sync.RWMutex
-	index   int //轮询计数
+	servers  []ServiceInstance
+	r        *rand.Rand
+	mu       sync.RWMutex
+	index    int //轮询计数
+	watchers watchers
 }
 
 func (m *MultiServerDiscovery) Refresh() error {
-	//TODO implement me
-	panic("implement me")
+	return nil
 }
 
-func (m *MultiServerDiscovery) Update(Servers []string) error {
-	//TODO implement me
-	panic("implement me")
+func (m *MultiServerDiscovery) Update(Servers []ServiceInstance) error {
+	m.mu.Lock()
+	events := m.setServers(Servers)
+	m.mu.Unlock()
+	m.watchers.publish(events...)
+	return nil
+}
+
+func (m *MultiServerDiscovery) Watch() <-chan Event {
+	return m.watchers.Watch()
+}
+
+// setServers 替换服务列表并返回新旧列表之间的差异，调用方需要持有 m.mu
+func (m *MultiServerDiscovery) setServers(servers []ServiceInstance) []Event {
+	old := make(map[string]ServiceInstance, len(m.servers))
+	for _, s := range m.servers {
+		old[s.Addr] = s
+	}
+	var events []Event
+	for _, s := range servers {
+		prev, ok := old[s.Addr]
+		switch {
+		case !ok:
+			events = append(events, Event{Type: ServerAdded, Addr: s.Addr, Instance: s})
+		case !reflect.DeepEqual(prev, s):
+			events = append(events, Event{Type: ServerUpdated, Addr: s.Addr, Instance: s})
+		}
+		delete(old, s.Addr)
+	}
+	for addr, s := range old {
+		events = append(events, Event{Type: ServerRemoved, Addr: addr, Instance: s})
+	}
+	m.servers = servers
+	if m.index < 0 {
+		m.index = 0
+	}
+	return events
 }
 
 type OrpcRegisterDiscovery struct {
 	*MultiServerDiscovery
 	registry   string
+	registries []string //registry 中以逗号分隔的多个注册中心地址，请求失败时换下一个
+	current    int
+	service    string //为空时发现注册中心上的全部地址
 	timeout    time.Duration
 	lastUpdate time.Time
+	revision   uint64
+	epoch      string     //revision 所属的注册中心 epoch，注册中心重启后不同，此时注册中心返回全量列表
+	refreshMu  sync.Mutex //同一时间只有一个 Refresh 访问注册中心
+	ctx        context.Context
+	cancel     context.CancelFunc
 }
 
+// NewOrpcRegisterDiscovery registryAddr 可以是逗号分隔的多个注册中心地址，例如注册中心集群的各个节点
 func NewOrpcRegisterDiscovery(registryAddr string, timeout time.Duration) *OrpcRegisterDiscovery {
+	return NewOrpcServiceDiscovery(registryAddr, "", timeout)
+}
+
+// NewOrpcServiceDiscovery 只发现注册中心上提供 service 服务的地址。它在后台向注册中心长轮询，
+// 不再使用时需要调用 Close，交给 NewXClient 的 discovery 会在 XClient.Close 时被关闭。
+// registryAddr 为空时不启动长轮询，Get 与 GetAll 返回错误
+func NewOrpcServiceDiscovery(registryAddr, service string, timeout time.Duration) *OrpcRegisterDiscovery {
 	if timeout == 0 {
 		timeout = DefaultUpdateTimeout
 	}
+	ctx, cancel := context.WithCancel(context.Background())
 	d := &OrpcRegisterDiscovery{
 		MultiServerDiscovery: NewMultiServerDiscovery(make([]string, 0)),
 		registry:             registryAddr,
+		registries:           strings.Split(registryAddr, ","),
+		service:              service,
 		timeout:              timeout,
+		ctx:                  ctx,
+		cancel:               cancel,
+	}
+	if registryAddr != "" {
+		go d.watchLoop()
 	}
 	return d
 }
 
 func NewMultiServerDiscovery(servers []string) *MultiServerDiscovery {
 	d := &MultiServerDiscovery{
-		servers: servers,
+		servers: instances(servers),
 		r:       rand.New(rand.NewSource(time.Now().UnixNano())),
 	}
 	if len(d.servers) == 0 {
@@ -73,71 +204,195 @@ func NewMultiServerDiscovery(servers []string) *MultiServerDiscovery {
 	d.index = d.r.Intn(len(d.servers))
 	return d
 }
-func (m *OrpcRegisterDiscovery) Refresh() error {
+
+// registryResponse 注册中心 JSON 协议的响应，Full 为 false 时只有 Added/Removed 有效
+type registryResponse struct {
+	Epoch     string            `json:"epoch"`
+	Revision  uint64            `json:"revision"`
+	Full      bool              `json:"full"`
+	Instances []ServiceInstance `json:"instances"`
+	Added     []ServiceInstance `json:"added"`
+	Removed   []string          `json:"removed"`
+}
+
+func (m *OrpcRegisterDiscovery) newRequest(ctx context.Context) (*http.Request, error) {
+	m.mu.RLock()
+	registry := m.registries[m.current]
+	m.mu.RUnlock()
+	req, err := http.NewRequestWithContext(ctx, "GET", registry, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if m.service != "" {
+		req.Header.Set("X-Orpc-Service", m.service)
+	}
+	return req, nil
+}
+
+func (m *OrpcRegisterDiscovery) do(req *http.Request) (*registryResponse, error) {
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		m.next(req.URL.String())
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		m.next(req.URL.String())
+		return nil, errors.New("unexpected registry response: " + resp.Status)
+	}
+	var body registryResponse
+	if err = json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, err
+	}
+	return &body, nil
+}
+
+// next 请求 registry 失败后换到下一个注册中心地址
+func (m *OrpcRegisterDiscovery) next(registry string) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
+	if m.registries[m.current] == registry {
+		m.current = (m.current + 1) % len(m.registries)
+	}
+}
+
+// Refresh 服务列表超过 timeout 没有更新时向注册中心拉取全量列表，并发的调用只会发出一个请求
+func (m *OrpcRegisterDiscovery) Refresh() error {
+	m.refreshMu.Lock()
+	defer m.refreshMu.Unlock()
+	m.mu.Lock()
 	if m.lastUpdate.Add(m.timeout).After(time.Now()) {
+		m.mu.Unlock()
 		return nil
 	}
+	m.mu.Unlock()
 	log.Println("OrpcRegisterDiscovery refresh", m.registry)
-	resp, err := http.Get(m.registry)
+	req, err := m.newRequest(context.Background())
 	if err != nil {
-		log.Println("OrpcRegisterDiscovery refresh error", err)
 		return err
 	}
-	defer resp.Body.Close()
-	servers := strings.Split(resp.Header.Get("X-Orpc-Servers"), ",")
-	m.servers = make([]string, 0, len(servers))
-	for _, server := range servers {
-		m.servers = append(m.servers, strings.TrimSpace(server))
+	body, err := m.do(req)
+	if err != nil {
+		log.Println("OrpcRegisterDiscovery refresh error", err)
+		return err
 	}
-	log.Println("OrpcRegisterDiscovery refresh", m.registry)
+	m.mu.Lock()
+	events := m.setServers(body.Instances)
+	m.revision, m.epoch = body.Revision, body.Epoch
 	m.lastUpdate = time.Now()
+	m.mu.Unlock()
+	m.watchers.publish(events...)
 	return nil
 }
 
-func (m *OrpcRegisterDiscovery) Update(Servers []string) error {
+func (m *OrpcRegisterDiscovery) Update(Servers []ServiceInstance) error {
 	m.mu.Lock()
-	defer m.mu.Unlock()
-	m.servers = Servers
+	events := m.setServers(Servers)
 	m.lastUpdate = time.Now()
+	m.mu.Unlock()
+	m.watchers.publish(events...)
+	return nil
+}
+
+// Close 停止后台的 watch 循环，并关闭所有 Watch 返回的 channel
+func (m *OrpcRegisterDiscovery) Close() error {
+	m.cancel()
+	m.watchers.close()
 	return nil
 }
 
-func (m *MultiServerDiscovery) Get(Mode SelectMode) (string, error) {
+// watchLoop 向注册中心发起长轮询，把返回的增量应用到服务列表上
+func (m *OrpcRegisterDiscovery) watchLoop() {
+	for m.ctx.Err() == nil {
+		if err := m.watch(); err != nil && m.ctx.Err() == nil {
+			log.Println("OrpcRegisterDiscovery watch error", err)
+			select {
+			case <-time.After(watchRetryInterval):
+			case <-m.ctx.Done():
+			}
+		}
+	}
+}
+
+func (m *OrpcRegisterDiscovery) watch() error {
 	m.mu.RLock()
-	defer m.mu.RUnlock()
-	n := len(m.servers)
-	if n == 0 {
-		return "", errors.New("no servers")
+	revision, epoch := m.revision, m.epoch
+	m.mu.RUnlock()
+	req, err := m.newRequest(m.ctx)
+	if err != nil {
+		return err
 	}
-	switch Mode {
-	case RandomSelect:
-		return m.servers[m.r.Intn(n)], nil
-	case RoundRobinSelect:
-		s := m.servers[m.index%n]
-		m.index = (m.index + 1) % n
-		return s, nil
-	default:
-		return "", errors.New("invalid select mode")
+	req.Header.Set("X-Orpc-Watch", strconv.FormatUint(revision, 10))
+	req.Header.Set("X-Orpc-Epoch", epoch)
+	req.Header.Set("X-Orpc-Watch-Timeout", defaultWatchTimeout.String())
+	body, err := m.do(req)
+	if err != nil {
+		return err
 	}
+	m.mu.Lock()
+	var events []Event
+	if body.Full {
+		events = m.setServers(body.Instances)
+	} else {
+		removed := make(map[string]bool)
+		for _, addr := range body.Removed {
+			removed[addr] = true
+		}
+		for _, s := range body.Added {
+			removed[s.Addr] = true
+		}
+		servers := make([]ServiceInstance, 0, len(m.servers))
+		for _, s := range m.servers {
+			if !removed[s.Addr] {
+				servers = append(servers, s)
+			}
+		}
+		events = m.setServers(append(servers, body.Added...))
+	}
+	m.revision, m.epoch = body.Revision, body.Epoch
+	m.lastUpdate = time.Now()
+	m.mu.Unlock()
+	m.watchers.publish(events...)
+	return nil
+}
+
+func (m *MultiServerDiscovery) Get(Mode SelectMode) (ServiceInstance, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return selectInstance(m.servers, Mode, m.r, &m.index)
 }
 
-func (m *MultiServerDiscovery) GetAll() ([]string, error) {
+func (m *MultiServerDiscovery) GetAll() ([]ServiceInstance, error) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
-	return append([]string{}, m.servers...), nil
+	return append([]ServiceInstance{}, m.servers...), nil
 }
 
-func (d *OrpcRegisterDiscovery) Get(Mode SelectMode) (string, error) {
+// ready 还没有拿到过服务列表时同步拉取一次；之后直接使用缓存，由 watchLoop 负责更新，
+// 注册中心不可用时继续使用最后一次拿到的列表
+func (d *OrpcRegisterDiscovery) ready() error {
 	if d.registry == "" {
-		return "", errors.New("no registry")
+		return errors.New("no registry")
+	}
+	d.mu.RLock()
+	loaded := !d.lastUpdate.IsZero()
+	d.mu.RUnlock()
+	if loaded {
+		return nil
+	}
+	return d.Refresh()
+}
+
+func (d *OrpcRegisterDiscovery) Get(Mode SelectMode) (ServiceInstance, error) {
+	if err := d.ready(); err != nil {
+		return ServiceInstance{}, err
 	}
 	return d.MultiServerDiscovery.Get(Mode)
 }
-func (d *OrpcRegisterDiscovery) GetAll() ([]string, error) {
-	if d.registry == "" {
-		return nil, errors.New("no registry")
+func (d *OrpcRegisterDiscovery) GetAll() ([]ServiceInstance, error) {
+	if err := d.ready(); err != nil {
+		return nil, err
 	}
 	return d.MultiServerDiscovery.GetAll()
 }
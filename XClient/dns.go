@@ -0,0 +1,255 @@
+package XClient
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultDNSTTL    = 30 * time.Second
+	dnsLookupLimit   = 5 * time.Second
+	dnsRetryInterval = time.Second //解析失败后至少等待这么久才再次解析
+)
+
+// Resolver DNSDiscovery 需要的解析能力，*net.Resolver 满足该接口，测试时可以替换成进程内的实现
+type Resolver interface {
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+var _ Resolver = (*net.Resolver)(nil)
+
+// DNSDiscovery 通过 DNS 发现服务，port 为 0 时解析 SRV 记录，否则解析 A/AAAA 记录并使用固定端口
+type DNSDiscovery struct {
+	*MultiServerDiscovery
+	resolver   Resolver
+	name       string
+	port       int
+	protocol   string
+	ttl        time.Duration
+	lastUpdate time.Time
+	lastFailed time.Time //最近一次解析失败的时间
+	priorities []uint16  //与 servers 一一对应，A/AAAA 记录全部为 0
+	refreshMu  sync.Mutex
+	done       chan struct{}
+	once       sync.Once
+}
+
+var _ Discovery = (*DNSDiscovery)(nil)
+
+// NewDNSSRVDiscovery 解析 name 的 SRV 记录，例如 _orpc._tcp.example.com，只使用优先级最高（数值最小）的一组，组内按权重选择
+func NewDNSSRVDiscovery(resolver Resolver, name string, ttl time.Duration) *DNSDiscovery {
+	return newDNSDiscovery(resolver, name, 0, ttl)
+}
+
+// NewDNSDiscovery 解析 host 的 A/AAAA 记录，每个地址都使用 port 端口
+func NewDNSDiscovery(resolver Resolver, host string, port int, ttl time.Duration) *DNSDiscovery {
+	return newDNSDiscovery(resolver, host, port, ttl)
+}
+
+func newDNSDiscovery(resolver Resolver, name string, port int, ttl time.Duration) *DNSDiscovery {
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	if ttl == 0 {
+		ttl = DefaultDNSTTL
+	}
+	d := &DNSDiscovery{
+		MultiServerDiscovery: NewMultiServerDiscovery(make([]string, 0)),
+		resolver:             resolver,
+		name:                 name,
+		port:                 port,
+		protocol:             "tcp",
+		ttl:                  ttl,
+		done:                 make(chan struct{}),
+	}
+	go d.refreshLoop()
+	return d
+}
+
+// SetProtocol 设置拼接到地址前的协议，默认为 tcp，例如设置为 http 后得到 http@host:port
+func (d *DNSDiscovery) SetProtocol(protocol string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.protocol = protocol
+}
+
+func (d *DNSDiscovery) refreshLoop() {
+	t := time.NewTicker(d.ttl)
+	defer t.Stop()
+	for {
+		select {
+		case <-d.done:
+			return
+		case <-t.C:
+			if err := d.Refresh(); err != nil {
+				log.Println("DNSDiscovery refresh error", err)
+			}
+		}
+	}
+}
+
+// Close 停止后台刷新，并关闭所有 Watch 返回的 channel
+func (d *DNSDiscovery) Close() error {
+	d.once.Do(func() { close(d.done) })
+	d.watchers.close()
+	return nil
+}
+
+func (d *DNSDiscovery) Refresh() error {
+	d.refreshMu.Lock()
+	defer d.refreshMu.Unlock()
+	d.mu.RLock()
+	now := time.Now()
+	fresh := d.lastUpdate.Add(d.ttl).After(now) || d.lastFailed.Add(min(d.ttl, dnsRetryInterval)).After(now)
+	protocol := d.protocol
+	d.mu.RUnlock()
+	if fresh {
+		return nil
+	}
+	servers, priorities, err := d.lookup(protocol)
+	d.mu.Lock()
+	if err != nil {
+		d.lastFailed = time.Now()
+		d.mu.Unlock()
+		return err
+	}
+	events := d.setServers(servers)
+	d.priorities = priorities
+	d.lastUpdate = time.Now()
+	d.mu.Unlock()
+	d.watchers.publish(events...)
+	return nil
+}
+
+// lookup 解析一次 DNS，返回的地址与优先级一一对应
+func (d *DNSDiscovery) lookup(protocol string) ([]ServiceInstance, []uint16, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dnsLookupLimit)
+	defer cancel()
+	var servers []ServiceInstance
+	var priorities []uint16
+	if d.port == 0 {
+		_, records, err := d.resolver.LookupSRV(ctx, "", "", d.name)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, srv := range records {
+			host := strings.TrimSuffix(srv.Target, ".")
+			servers = append(servers, ServiceInstance{
+				Addr:   protocol + "@" + net.JoinHostPort(host, strconv.Itoa(int(srv.Port))),
+				Weight: int(srv.Weight),
+			})
+			priorities = append(priorities, srv.Priority)
+		}
+	} else {
+		addrs, err := d.resolver.LookupIPAddr(ctx, d.name)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, addr := range addrs {
+			servers = append(servers, ServiceInstance{Addr: protocol + "@" + net.JoinHostPort(addr.String(), strconv.Itoa(d.port))})
+			priorities = append(priorities, 0)
+		}
+	}
+	if len(servers) == 0 {
+		return nil, nil, errors.New("DNSDiscovery: no records for " + d.name)
+	}
+	return servers, priorities, nil
+}
+
+// Update 手动设置服务列表，所有地址视为同一优先级和相同权重
+func (d *DNSDiscovery) Update(Servers []ServiceInstance) error {
+	d.mu.Lock()
+	events := d.setServers(Servers)
+	d.priorities = make([]uint16, len(Servers))
+	d.lastUpdate = time.Now()
+	d.mu.Unlock()
+	d.watchers.publish(events...)
+	return nil
+}
+
+// ready 从来没有解析成功过时同步解析一次；之后直接使用缓存，由 refreshLoop 按 TTL 更新，
+// 解析失败时继续使用上一次的结果
+func (d *DNSDiscovery) ready() {
+	d.mu.RLock()
+	resolved := !d.lastUpdate.IsZero()
+	d.mu.RUnlock()
+	if resolved {
+		return
+	}
+	if err := d.Refresh(); err != nil {
+		log.Println("DNSDiscovery refresh error", err)
+	}
+}
+
+func (d *DNSDiscovery) Get(Mode SelectMode) (ServiceInstance, error) {
+	d.ready()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(d.servers) == 0 {
+		return ServiceInstance{}, errors.New("no servers")
+	}
+	group := d.topGroup()
+	if Mode == RandomSelect || Mode == WeightedRandomSelect {
+		return d.servers[d.pickWeighted(group)], nil
+	}
+	candidates := make([]ServiceInstance, 0, len(group))
+	for _, i := range group {
+		candidates = append(candidates, d.servers[i])
+	}
+	return selectInstance(candidates, Mode, d.r, &d.index)
+}
+
+// topGroup 返回优先级最高（数值最小）的一组记录在 servers 中的下标，调用方需要持有 d.mu
+func (d *DNSDiscovery) topGroup() []int {
+	var group []int
+	for i, p := range d.priorities {
+		if len(group) > 0 && p > d.priorities[group[0]] {
+			continue
+		}
+		if len(group) > 0 && p < d.priorities[group[0]] {
+			group = group[:0]
+		}
+		group = append(group, i)
+	}
+	return group
+}
+
+// pickWeighted 按 RFC 2782 的方式在同一优先级的记录中按权重随机选择，权重全为 0 时等概率选择
+func (d *DNSDiscovery) pickWeighted(group []int) int {
+	total := 0
+	for _, i := range group {
+		total += d.servers[i].Weight
+	}
+	if total == 0 {
+		return group[d.r.Intn(len(group))]
+	}
+	n := d.r.Intn(total)
+	for _, i := range group {
+		n -= d.servers[i].Weight
+		if n < 0 {
+			return i
+		}
+	}
+	return group[len(group)-1]
+}
+
+// GetAll 与 Get 一样只返回优先级最高的一组，备用优先级的记录在这一组全部消失之前不会收到请求，
+// XClient 的选择器与健康检查也只在这一组中挑选
+func (d *DNSDiscovery) GetAll() ([]ServiceInstance, error) {
+	d.ready()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	group := d.topGroup()
+	servers := make([]ServiceInstance, 0, len(group))
+	for _, i := range group {
+		servers = append(servers, d.servers[i])
+	}
+	return servers, nil
+}
@@ -0,0 +1,128 @@
+package XClient
+
+import (
+	"errors"
+	"math/rand"
+)
+
+// ServiceInstance 服务的一个实例，Addr 为 XDial 使用的 protocol@addr 格式
+type ServiceInstance struct {
+	Service    string            `json:"service,omitempty"`
+	Addr       string            `json:"addr"`
+	Weight     int               `json:"weight,omitempty"`
+	Zone       string            `json:"zone,omitempty"`
+	Version    string            `json:"version,omitempty"`
+	Tags       []string          `json:"tags,omitempty"`
+	Metadata   map[string]string `json:"metadata,omitempty"`
+	Unverified bool              `json:"unverified,omitempty"` //注册中心重启后还没有收到过心跳的实例
+	Unhealthy  bool              `json:"unhealthy,omitempty"`  //没有通过注册中心的健康检查
+	Drained    bool              `json:"drained,omitempty"`    //被管理员摘除了流量
+}
+
+func (s ServiceInstance) HasTag(tag string) bool {
+	for _, t := range s.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// instances 把地址列表转换为没有元数据的实例
+func instances(servers []string) []ServiceInstance {
+	list := make([]ServiceInstance, 0, len(servers))
+	for _, s := range servers {
+		list = append(list, ServiceInstance{Addr: s})
+	}
+	return list
+}
+
+// Selector 在选择实例之前对候选实例进行过滤，返回空列表表示没有可用的实例
+type Selector func(instances []ServiceInstance) []ServiceInstance
+
+// PreferZone 存在 zone 中的实例时只使用它们，否则退回到全部实例
+func PreferZone(zone string) Selector {
+	return func(instances []ServiceInstance) []ServiceInstance {
+		var local []ServiceInstance
+		for _, s := range instances {
+			if s.Zone == zone {
+				local = append(local, s)
+			}
+		}
+		if len(local) == 0 {
+			return instances
+		}
+		return local
+	}
+}
+
+// PinVersion 只使用 version 版本的实例
+func PinVersion(version string) Selector {
+	return func(instances []ServiceInstance) []ServiceInstance {
+		var pinned []ServiceInstance
+		for _, s := range instances {
+			if s.Version == version {
+				pinned = append(pinned, s)
+			}
+		}
+		return pinned
+	}
+}
+
+// RequireTags 只使用带有全部 tags 的实例
+func RequireTags(tags ...string) Selector {
+	return func(instances []ServiceInstance) []ServiceInstance {
+		var tagged []ServiceInstance
+	next:
+		for _, s := range instances {
+			for _, tag := range tags {
+				if !s.HasTag(tag) {
+					continue next
+				}
+			}
+			tagged = append(tagged, s)
+		}
+		return tagged
+	}
+}
+
+// selectInstance 按 mode 从 instances 中选出一个，index 为轮询计数
+func selectInstance(instances []ServiceInstance, mode SelectMode, r *rand.Rand, index *int) (ServiceInstance, error) {
+	n := len(instances)
+	if n == 0 {
+		return ServiceInstance{}, errors.New("no servers")
+	}
+	switch mode {
+	case RandomSelect:
+		return instances[r.Intn(n)], nil
+	case RoundRobinSelect:
+		if *index < 0 {
+			*index = 0
+		}
+		s := instances[*index%n]
+		*index = (*index + 1) % n
+		return s, nil
+	case WeightedRandomSelect:
+		//没有设置权重的实例按 1 计算
+		total := 0
+		for _, s := range instances {
+			total += weightOf(s)
+		}
+		x := r.Intn(total)
+		for _, s := range instances {
+			if x -= weightOf(s); x < 0 {
+				return s, nil
+			}
+		}
+		return instances[n-1], nil
+	default:
+		return ServiceInstance{}, errors.New("invalid select mode")
+	}
+}
+
+func weightOf(s ServiceInstance) int {
+	if s.Weight <= 0 {
+		return 1
+	}
+	return s.Weight
+}
@@ -4,8 +4,10 @@ import (
 	"context"
 	Orpc "github.com/R-Goys/Orpc/server"
 	"io"
+	"math/rand"
 	"reflect"
 	"sync"
+	"time"
 )
 
 type XClient struct {
@@ -14,19 +16,68 @@ type XClient struct {
 	opt     *Orpc.Option
 	mu      sync.Mutex
 	clients map[string]*Orpc.Client
+	done    chan struct{}
+	once    sync.Once
+
+	selMu     sync.Mutex
+	selectors []Selector
+	r         *rand.Rand
+	index     int //使用 Selector 时的轮询计数
+
+	healthMu   sync.Mutex
+	notServing map[string]bool //最近一次健康检查中 NOT_SERVING 或者无法访问的地址，见 EnableHealthCheck
 }
 
+// Close 关闭所有连接，如果 discovery 实现了 io.Closer（例如 OrpcRegisterDiscovery、DNSDiscovery）也会关闭它
 func (X *XClient) Close() error {
+	X.once.Do(func() { close(X.done) })
 	X.mu.Lock()
-	defer X.mu.Unlock()
 	for key, client := range X.clients {
 		_ = client.Close()
 		delete(X.clients, key)
 	}
+	X.mu.Unlock()
+	if c, ok := X.d.(io.Closer); ok {
+		return c.Close()
+	}
 	return nil
 }
+
+// NewXClient XClient 接管 d，Close 时一并关闭，d 不应再被其他 XClient 共用
 func NewXClient(d Discovery, mode SelectMode, opt *Orpc.Option) *XClient {
-	return &XClient{d: d, mode: mode, opt: opt, clients: make(map[string]*Orpc.Client)}
+	x := &XClient{
+		d:       d,
+		mode:    mode,
+		opt:     opt,
+		clients: make(map[string]*Orpc.Client),
+		done:    make(chan struct{}),
+		r:       rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	go x.watch(d.Watch())
+	return x
+}
+
+// watch 订阅服务列表的变更，关闭已经被移除的服务的连接
+func (x *XClient) watch(events <-chan Event) {
+	for {
+		select {
+		case <-x.done:
+			return
+		case e, ok := <-events:
+			if !ok {
+				return
+			}
+			if e.Type != ServerRemoved {
+				continue
+			}
+			x.mu.Lock()
+			if client, ok := x.clients[e.Addr]; ok {
+				_ = client.Close()
+				delete(x.clients, e.Addr)
+			}
+			x.mu.Unlock()
+		}
+	}
 }
 
 var _ io.Closer = (*XClient)(nil)
@@ -38,7 +89,7 @@ func (x *XClient) dial(rpcAddr string) (*Orpc.Client, error) {
 	if ok && !client.IsAvailable() {
 		_ = client.Close()
 		delete(x.clients, rpcAddr)
-		return client, nil
+		client = nil
 	}
 	if client == nil {
 		var err error
@@ -59,16 +110,128 @@ func (x *XClient) call(rpcAddr string, ctx context.Context, serviceMethod string
 	return client.Call(ctx, serviceMethod, args, reply)
 }
 
+// SetSelectors 设置选择实例前依次执行的过滤器，例如 PreferZone、PinVersion，用于按机房或版本路由
+func (x *XClient) SetSelectors(selectors ...Selector) {
+	x.selMu.Lock()
+	defer x.selMu.Unlock()
+	x.selectors = selectors
+}
+
+// EnableHealthCheck 每隔 interval 调用各实例的 Health.Check，选择实例时跳过 NOT_SERVING 或者无法访问的实例
+func (x *XClient) EnableHealthCheck(interval time.Duration) {
+	go x.healthLoop(interval)
+}
+
+func (x *XClient) healthLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-x.done:
+			return
+		case <-ticker.C:
+		}
+		servers, err := x.d.GetAll()
+		if err != nil {
+			continue
+		}
+		notServing := make(map[string]bool)
+		var mu sync.Mutex
+		var wg sync.WaitGroup
+		for _, s := range servers {
+			wg.Add(1)
+			go func(s ServiceInstance) {
+				defer wg.Done()
+				ctx, cancel := context.WithTimeout(context.Background(), interval)
+				defer cancel()
+				status := Orpc.StatusUnknown
+				client, err := x.dial(s.Addr)
+				if err == nil {
+					status, err = client.CheckHealth(ctx, s.Service)
+				}
+				if err != nil || status == Orpc.StatusNotServing {
+					mu.Lock()
+					notServing[s.Addr] = true
+					mu.Unlock()
+				}
+			}(s)
+		}
+		wg.Wait()
+		x.healthMu.Lock()
+		x.notServing = notServing
+		x.healthMu.Unlock()
+	}
+}
+
+func (x *XClient) isServing(s ServiceInstance) bool {
+	x.healthMu.Lock()
+	defer x.healthMu.Unlock()
+	return !s.Unhealthy && !s.Drained && !x.notServing[s.Addr]
+}
+
+// serving 去掉被摘除的实例，以及注册中心标记为不健康、自己检查为 NOT_SERVING 的实例，
+// 剩下的实例全部不健康时退回到这些不健康的实例
+func (x *XClient) serving(servers []ServiceInstance) []ServiceInstance {
+	var list, undrained []ServiceInstance
+	for _, s := range servers {
+		if s.Drained {
+			continue
+		}
+		undrained = append(undrained, s)
+		if x.isServing(s) {
+			list = append(list, s)
+		}
+	}
+	if len(list) == 0 {
+		return undrained
+	}
+	return list
+}
+
+// instances 返回健康的、经过 Selector 过滤后的全部实例
+func (x *XClient) instances() ([]ServiceInstance, error) {
+	servers, err := x.d.GetAll()
+	if err != nil {
+		return nil, err
+	}
+	servers = x.serving(servers)
+	x.selMu.Lock()
+	defer x.selMu.Unlock()
+	for _, selector := range x.selectors {
+		servers = selector(servers)
+	}
+	return servers, nil
+}
+
+func (x *XClient) get() (ServiceInstance, error) {
+	x.selMu.Lock()
+	filtered := len(x.selectors) > 0
+	x.selMu.Unlock()
+	if !filtered {
+		s, err := x.d.Get(x.mode)
+		if err != nil || x.isServing(s) {
+			return s, err
+		}
+	}
+	servers, err := x.instances()
+	if err != nil {
+		return ServiceInstance{}, err
+	}
+	x.selMu.Lock()
+	defer x.selMu.Unlock()
+	return selectInstance(servers, x.mode, x.r, &x.index)
+}
+
 func (x *XClient) Call(ctx context.Context, serviceMethod string, args, reply interface{}) error {
-	rpcAddr, err := x.d.Get(x.mode)
+	server, err := x.get()
 	if err != nil {
 		return err
 	}
-	return x.call(rpcAddr, ctx, serviceMethod, args, reply)
+	return x.call(server.Addr, ctx, serviceMethod, args, reply)
 }
 
 func (x *XClient) Broadcast(ctx context.Context, serviceMethod string, args, reply interface{}) error {
-	servers, err := x.d.GetAll()
+	servers, err := x.instances()
 	if err != nil {
 		return err
 	}
@@ -78,7 +241,7 @@ func (x *XClient) Broadcast(ctx context.Context, serviceMethod string, args, rep
 	replyDone := reply == nil
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
-	for _, rpcAddr := range servers {
+	for _, server := range servers {
 		wg.Add(1)
 		go func(rpcAddr string) {
 			defer wg.Done()
@@ -86,7 +249,7 @@ func (x *XClient) Broadcast(ctx context.Context, serviceMethod string, args, rep
 			if reply != nil {
 				clonedReply = reflect.New(reflect.ValueOf(reply).Elem().Type()).Interface()
 			}
-			err = x.call(rpcAddr, ctx, serviceMethod, args, clonedReply)
+			err := x.call(rpcAddr, ctx, serviceMethod, args, clonedReply)
 			mu.Lock()
 			if err != nil && e == nil {
 				e = err
@@ -97,8 +260,15 @@ func (x *XClient) Broadcast(ctx context.Context, serviceMethod string, args, rep
 				replyDone = true
 			}
 			mu.Unlock()
-		}(rpcAddr)
+		}(server.Addr)
 	}
 	wg.Wait()
 	return e
 }
+
+// CallTyped 以 Req 为参数调用 method，并把返回值解码为 Resp
+func CallTyped[Req, Resp any](ctx context.Context, x *XClient, method string, req Req) (Resp, error) {
+	var resp Resp
+	err := x.Call(ctx, method, req, &resp)
+	return resp, err
+}
@@ -0,0 +1,230 @@
+// orpc-gen 为 Go 包中的服务类型生成带类型的客户端与服务端接口：
+//
+//	orpc-gen [-dir .] [-types Foo,Bar] [-o orpc_gen.go]
+//
+// 满足 Server.Register 规则的方法（导出、func(Args, *Reply) error、参数类型导出或为内置类型）会生成
+//
+//	type FooService interface { Sum(args Args, reply *int) error } //以及 var _ FooService = (*Foo)(nil)
+//	type FooClient struct { ... }                                  //func (c *FooClient) Sum(ctx, Args) (int, error)
+//
+// FooClient 可以包装 *Orpc.Client 或者 *XClient.XClient。通常配合 go:generate 使用
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const orpcPath = "github.com/R-Goys/Orpc/server"
+
+func main() {
+	dir := flag.String("dir", ".", "directory of the package to scan")
+	names := flag.String("types", "", "comma separated service types, empty means every exported type with at least one service method")
+	output := flag.String("o", "orpc_gen.go", "output file, relative to -dir")
+	flag.Parse()
+	if err := generate(*dir, *names, *output); err != nil {
+		fmt.Fprintln(os.Stderr, "orpc-gen:", err)
+		os.Exit(1)
+	}
+}
+
+func generate(dir, names, output string) error {
+	pkg, err := load(dir, output)
+	if err != nil {
+		return err
+	}
+	var wanted []string
+	if names != "" {
+		wanted = strings.Split(names, ",")
+	} else {
+		wanted = pkg.Scope().Names()
+	}
+	g := &generator{pkg: pkg, imports: map[string]string{"context": "context", orpcPath: "Orpc"}}
+	for _, name := range wanted {
+		obj, ok := pkg.Scope().Lookup(name).(*types.TypeName)
+		if !ok {
+			if names != "" {
+				return fmt.Errorf("type %s not found in package %s", name, pkg.Name())
+			}
+			continue
+		}
+		methods := serviceMethods(obj)
+		if len(methods) == 0 {
+			if names != "" {
+				return fmt.Errorf("type %s has no methods usable as Orpc service methods", name)
+			}
+			continue
+		}
+		g.service(obj, methods)
+	}
+	if g.body.Len() == 0 {
+		return errors.New("no service types found in " + dir)
+	}
+	src, err := format.Source(g.file())
+	if err != nil {
+		return fmt.Errorf("format generated code: %v", err)
+	}
+	return os.WriteFile(filepath.Join(dir, output), src, 0o644)
+}
+
+// load 解析并检查 dir 中的包，跳过测试文件以及上一次生成的文件
+func load(dir, output string) (*types.Package, error) {
+	fset := token.NewFileSet()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []*ast.File
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, ".go") || strings.HasSuffix(name, "_test.go") || name == filepath.Base(output) {
+			continue
+		}
+		f, err := parser.ParseFile(fset, filepath.Join(dir, name), nil, 0)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	if len(files) == 0 {
+		return nil, errors.New("no Go files in " + dir)
+	}
+	conf := types.Config{Importer: importer.ForCompiler(fset, "source", nil)}
+	return conf.Check(files[0].Name.Name, fset, files, nil)
+}
+
+// serviceMethods 返回 obj 的指针方法集中会被 Server.Register 注册的方法，规则与 registerMethods 一致
+func serviceMethods(obj *types.TypeName) []*types.Func {
+	if !obj.Exported() {
+		return nil
+	}
+	if _, ok := obj.Type().Underlying().(*types.Interface); ok {
+		return nil
+	}
+	var methods []*types.Func
+	set := types.NewMethodSet(types.NewPointer(obj.Type()))
+	for i := 0; i < set.Len(); i++ {
+		f := set.At(i).Obj().(*types.Func)
+		sig := f.Type().(*types.Signature)
+		if !f.Exported() || sig.Params().Len() != 2 || sig.Results().Len() != 1 || sig.Variadic() {
+			continue
+		}
+		if !types.Identical(sig.Results().At(0).Type(), types.Universe.Lookup("error").Type()) {
+			continue
+		}
+		arg, reply := sig.Params().At(0).Type(), sig.Params().At(1).Type()
+		if _, ok := reply.(*types.Pointer); !ok {
+			continue
+		}
+		if !exportedOrBuiltin(arg) || !exportedOrBuiltin(reply) {
+			continue
+		}
+		methods = append(methods, f)
+	}
+	sort.Slice(methods, func(i, j int) bool { return methods[i].Name() < methods[j].Name() })
+	return methods
+}
+
+// exportedOrBuiltin 对应 isExportedOrBuiltinType：具名类型需要导出，匿名类型（包括指针）都可以
+func exportedOrBuiltin(t types.Type) bool {
+	named, ok := t.(*types.Named)
+	if !ok {
+		return true
+	}
+	return named.Obj().Pkg() == nil || named.Obj().Exported()
+}
+
+type generator struct {
+	pkg     *types.Package
+	imports map[string]string //包路径 -> 生成代码中使用的名称
+	body    bytes.Buffer
+}
+
+func (g *generator) qualifier(p *types.Package) string {
+	if p == g.pkg {
+		return ""
+	}
+	if name, ok := g.imports[p.Path()]; ok {
+		return name
+	}
+	//不同路径的同名包依次使用 types、types1、types2 作为名称
+	name := p.Name()
+	for i := 1; g.used(name); i++ {
+		name = fmt.Sprintf("%s%d", p.Name(), i)
+	}
+	g.imports[p.Path()] = name
+	return name
+}
+
+// used 判断 name 是否已经被导入的包或者包内的声明占用
+func (g *generator) used(name string) bool {
+	for _, n := range g.imports {
+		if n == name {
+			return true
+		}
+	}
+	return g.pkg.Scope().Lookup(name) != nil
+}
+
+func (g *generator) typeString(t types.Type) string {
+	return types.TypeString(t, g.qualifier)
+}
+
+func (g *generator) service(obj *types.TypeName, methods []*types.Func) {
+	name := obj.Name()
+	fmt.Fprintf(&g.body, "// %sService %s 作为 Orpc 服务时提供的方法\n", name, name)
+	fmt.Fprintf(&g.body, "type %sService interface {\n", name)
+	for _, m := range methods {
+		sig := m.Type().(*types.Signature)
+		fmt.Fprintf(&g.body, "\t%s(args %s, reply %s) error\n", m.Name(), g.typeString(sig.Params().At(0).Type()), g.typeString(sig.Params().At(1).Type()))
+	}
+	fmt.Fprintf(&g.body, "}\n\n")
+	fmt.Fprintf(&g.body, "var _ %sService = (*%s)(nil)\n\n", name, name)
+
+	fmt.Fprintf(&g.body, "// %sClient 以 %s 服务的方法签名调用远端，c 可以是 *Orpc.Client 或者 *XClient.XClient\n", name, name)
+	fmt.Fprintf(&g.body, "type %sClient struct {\n\tc Orpc.Caller\n}\n\n", name)
+	fmt.Fprintf(&g.body, "func New%sClient(c Orpc.Caller) *%sClient {\n\treturn &%sClient{c: c}\n}\n\n", name, name, name)
+	for _, m := range methods {
+		sig := m.Type().(*types.Signature)
+		arg := g.typeString(sig.Params().At(0).Type())
+		reply := g.typeString(sig.Params().At(1).Type().(*types.Pointer).Elem())
+		fmt.Fprintf(&g.body, "func (c *%sClient) %s(ctx context.Context, args %s) (%s, error) {\n", name, m.Name(), arg, reply)
+		fmt.Fprintf(&g.body, "\tvar reply %s\n", reply)
+		fmt.Fprintf(&g.body, "\terr := c.c.Call(ctx, %q, args, &reply)\n", name+"."+m.Name())
+		fmt.Fprintf(&g.body, "\treturn reply, err\n}\n\n")
+	}
+}
+
+func (g *generator) file() []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "// Code generated by orpc-gen. DO NOT EDIT.\n\n")
+	fmt.Fprintf(&buf, "package %s\n\nimport (\n", g.pkg.Name())
+	paths := make([]string, 0, len(g.imports))
+	for path := range g.imports {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		name := g.imports[path]
+		if name == filepath.Base(path) {
+			fmt.Fprintf(&buf, "\t%q\n", path)
+		} else {
+			fmt.Fprintf(&buf, "\t%s %q\n", name, path)
+		}
+	}
+	fmt.Fprintf(&buf, ")\n\n")
+	buf.Write(g.body.Bytes())
+	return buf.Bytes()
+}
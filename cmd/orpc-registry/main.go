@@ -0,0 +1,323 @@
+// orpc-registry 运行与管理 Orpc 注册中心：
+//
+//	orpc-registry serve [flags]
+//	orpc-registry list [flags] [service]
+//	orpc-registry deregister [flags] <service> <addr>
+//	orpc-registry drain [flags] [-undo] <service> <addr>
+//	orpc-registry watch [flags] [service]
+//
+// 管理命令通过注册中心的 JSON API 完成，-registry 可以是逗号分隔的多个地址，依次尝试直到有一个可用
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/R-Goys/Orpc/Registry"
+)
+
+const usage = `usage:
+  orpc-registry serve [flags]
+  orpc-registry list [flags] [service]
+  orpc-registry deregister [flags] <service> <addr>
+  orpc-registry drain [flags] [-undo] <service> <addr>
+  orpc-registry watch [flags] [service]
+
+run "orpc-registry <command> -h" for flags`
+
+const defaultRegistry = "http://localhost:9999/Orpc/registry"
+
+func main() {
+	if err := run(os.Args[1:], os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "orpc-registry:", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return errors.New(usage)
+	}
+	if args[0] == "serve" {
+		return serve(args[1:])
+	}
+	fs := flag.NewFlagSet("orpc-registry "+args[0], flag.ContinueOnError)
+	registry := fs.String("registry", defaultRegistry, "registry URL(s), comma separated")
+	undo := fs.Bool("undo", false, "drain: route traffic to the instance again")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	c := &client{registries: strings.Split(*registry, ","), http: &http.Client{Timeout: time.Minute}}
+	rest := fs.Args()
+	switch args[0] {
+	case "list":
+		service := ""
+		if len(rest) > 0 {
+			service = rest[0]
+		}
+		return list(c, service, out)
+	case "deregister":
+		if len(rest) != 2 {
+			return errors.New(usage)
+		}
+		return c.instanceOp("DELETE", rest[0], rest[1], "")
+	case "drain":
+		if len(rest) != 2 {
+			return errors.New(usage)
+		}
+		method := "PUT"
+		if *undo {
+			method = "DELETE"
+		}
+		return c.instanceOp(method, rest[0], rest[1], "/drain")
+	case "watch":
+		service := ""
+		if len(rest) > 0 {
+			service = rest[0]
+		}
+		return watch(c, service, out)
+	default:
+		return errors.New(usage)
+	}
+}
+
+func serve(args []string) error {
+	fs := flag.NewFlagSet("orpc-registry serve", flag.ContinueOnError)
+	addr := fs.String("addr", ":9999", "address to listen on")
+	path := fs.String("path", "/Orpc/registry", "HTTP path of the registry")
+	timeout := fs.Duration("timeout", 5*time.Second, "instances without a heartbeat for this long are removed, 0 keeps them forever")
+	data := fs.String("data", "", "directory to persist the registry (or the cluster node's raft state) in, empty keeps it in memory")
+	self := fs.String("self", "", "cluster mode: full URL of this node, e.g. http://10.0.0.1:9999/Orpc/registry")
+	peers := fs.String("peers", "", "cluster mode: comma separated URLs of all nodes, including -self")
+	healthCheck := fs.Duration("health-check", 0, "probe instances with Health.Check at this interval, 0 disables it")
+	healthFailures := fs.Int("health-failures", 3, "consecutive failed probes before an instance is marked unhealthy")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var r *Registry.ORegistry
+	switch {
+	case *self != "" || *peers != "":
+		if *self == "" || *peers == "" {
+			return errors.New("-self and -peers must be set together")
+		}
+		if *data == "" {
+			r = Registry.NewCluster(*timeout, *self, strings.Split(*peers, ","))
+			break
+		}
+		var err error
+		if r, err = Registry.OpenCluster(*timeout, *data, *self, strings.Split(*peers, ",")); err != nil {
+			return err
+		}
+	case *data != "":
+		var err error
+		if r, err = Registry.Open(*timeout, *data); err != nil {
+			return err
+		}
+	default:
+		r = Registry.New(*timeout)
+	}
+	defer func() { _ = r.Close() }()
+	if *healthCheck > 0 {
+		r.EnableHealthCheck(*healthCheck, *healthFailures)
+	}
+	log.Println("orpc-registry: serving", *path, "on", *addr)
+	return http.ListenAndServe(*addr, r.Handler(*path))
+}
+
+// client 注册中心 JSON API 的客户端
+type client struct {
+	registries []string
+	http       *http.Client
+}
+
+// do 依次向每个注册中心发送请求，直到有一个返回了非 5xx 的响应
+func (c *client) do(method, path string, header http.Header) (*http.Response, error) {
+	var err error
+	for _, registry := range c.registries {
+		var req *http.Request
+		if req, err = http.NewRequest(method, strings.TrimSuffix(registry, "/")+path, nil); err != nil {
+			return nil, err
+		}
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		var resp *http.Response
+		if resp, err = c.http.Do(req); err != nil {
+			continue
+		}
+		if resp.StatusCode >= 500 {
+			_ = resp.Body.Close()
+			err = errors.New(registry + ": " + resp.Status)
+			continue
+		}
+		return resp, nil
+	}
+	return nil, err
+}
+
+func (c *client) get(path string, header http.Header, v interface{}) error {
+	resp, err := c.do("GET", path, header)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return apiError(resp)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func (c *client) instanceOp(method, service, addr, suffix string) error {
+	resp, err := c.do(method, "/v1/services/"+url.PathEscape(service)+"/instances/"+url.PathEscape(addr)+suffix, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		return apiError(resp)
+	}
+	return nil
+}
+
+func apiError(resp *http.Response) error {
+	var body struct{ Error string }
+	if json.NewDecoder(resp.Body).Decode(&body) == nil && body.Error != "" {
+		return errors.New(body.Error)
+	}
+	return errors.New("unexpected response: " + resp.Status)
+}
+
+type instancesResponse struct {
+	Epoch     string                `json:"epoch"`
+	Revision  uint64                `json:"revision"`
+	Full      bool                  `json:"full"`
+	Instances []Registry.ServerItem `json:"instances"`
+	Added     []Registry.ServerItem `json:"added"`
+	Removed   []string              `json:"removed"`
+}
+
+func list(c *client, service string, out io.Writer) error {
+	services := []string{service}
+	if service == "" {
+		var resp struct {
+			Services []struct{ Name string }
+		}
+		if err := c.get("/v1/services", nil, &resp); err != nil {
+			return err
+		}
+		services = services[:0]
+		for _, s := range resp.Services {
+			services = append(services, s.Name)
+		}
+	}
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "SERVICE\tADDR\tSTATUS\tHEARTBEAT\tWEIGHT\tZONE\tVERSION\tTAGS\tMETADATA")
+	now := time.Now()
+	for _, name := range services {
+		var resp instancesResponse
+		if err := c.get("/v1/services/"+url.PathEscape(name)+"/instances", nil, &resp); err != nil {
+			return err
+		}
+		for _, s := range resp.Instances {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s ago\t%d\t%s\t%s\t%s\t%s\n", name, s.Addr, status(s),
+				now.Sub(s.LastHeartbeat).Truncate(time.Second), s.Weight, s.Zone, s.Version,
+				strings.Join(s.Tags, ","), formatMetadata(s.Metadata))
+		}
+	}
+	return w.Flush()
+}
+
+func status(s Registry.ServerItem) string {
+	var flags []string
+	if s.Drained {
+		flags = append(flags, "drained")
+	}
+	if s.Unhealthy {
+		flags = append(flags, "unhealthy")
+	}
+	if s.Unverified {
+		flags = append(flags, "unverified")
+	}
+	if len(flags) == 0 {
+		return "ok"
+	}
+	return strings.Join(flags, ",")
+}
+
+func formatMetadata(metadata map[string]string) string {
+	keys := make([]string, 0, len(metadata))
+	for k := range metadata {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for i, k := range keys {
+		keys[i] = k + "=" + metadata[k]
+	}
+	return strings.Join(keys, ",")
+}
+
+// watch 长轮询注册中心，把每次变更打印成一行，service 为空时通过旧协议的地址关注全部服务
+func watch(c *client, service string, out io.Writer) error {
+	get := func(revision, epoch string, v *instancesResponse) error {
+		if service != "" {
+			path := "/v1/services/" + url.PathEscape(service) + "/instances"
+			if revision != "" {
+				path += "?watch=" + revision + "&epoch=" + url.QueryEscape(epoch)
+			}
+			return c.get(path, nil, v)
+		}
+		header := http.Header{"Accept": {"application/json"}}
+		if revision != "" {
+			header.Set("X-Orpc-Watch", revision)
+			header.Set("X-Orpc-Epoch", epoch)
+		}
+		return c.get("", header, v)
+	}
+	var resp instancesResponse
+	if err := get("", "", &resp); err != nil {
+		return err
+	}
+	printInstances(out, "", resp.Instances)
+	revision, epoch := resp.Revision, resp.Epoch
+	for {
+		resp = instancesResponse{}
+		if err := get(strconv.FormatUint(revision, 10), epoch, &resp); err != nil {
+			return err
+		}
+		if resp.Revision == revision && resp.Epoch == epoch {
+			continue
+		}
+		revision, epoch = resp.Revision, resp.Epoch
+		if resp.Full {
+			printInstances(out, "=", resp.Instances)
+			continue
+		}
+		printInstances(out, "+", resp.Added)
+		for _, addr := range resp.Removed {
+			fmt.Fprintf(out, "%s - %s\n", time.Now().Format(time.TimeOnly), addr)
+		}
+	}
+}
+
+func printInstances(out io.Writer, prefix string, instances []Registry.ServerItem) {
+	for _, s := range instances {
+		line := time.Now().Format(time.TimeOnly) + " "
+		if prefix != "" {
+			line += prefix + " "
+		}
+		fmt.Fprintf(out, "%s%s %s %s\n", line, s.Service, s.Addr, status(s))
+	}
+}
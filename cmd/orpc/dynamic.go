@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"time"
+
+	Orpc "github.com/R-Goys/Orpc/server"
+)
+
+var basicTypes = map[string]reflect.Type{
+	"bool":       reflect.TypeOf(false),
+	"int":        reflect.TypeOf(int(0)),
+	"int8":       reflect.TypeOf(int8(0)),
+	"int16":      reflect.TypeOf(int16(0)),
+	"int32":      reflect.TypeOf(int32(0)),
+	"int64":      reflect.TypeOf(int64(0)),
+	"uint":       reflect.TypeOf(uint(0)),
+	"uint8":      reflect.TypeOf(uint8(0)),
+	"uint16":     reflect.TypeOf(uint16(0)),
+	"uint32":     reflect.TypeOf(uint32(0)),
+	"uint64":     reflect.TypeOf(uint64(0)),
+	"uintptr":    reflect.TypeOf(uintptr(0)),
+	"float32":    reflect.TypeOf(float32(0)),
+	"float64":    reflect.TypeOf(float64(0)),
+	"complex64":  reflect.TypeOf(complex64(0)),
+	"complex128": reflect.TypeOf(complex128(0)),
+	"string":     reflect.TypeOf(""),
+	"interface":  reflect.TypeOf((*interface{})(nil)).Elem(),
+}
+
+// knownTypes 自己实现了编码、不能按结构重建的具名类型
+var knownTypes = map[string]reflect.Type{
+	"time.Time": reflect.TypeOf(time.Time{}),
+}
+
+// buildType 根据 Reflection 返回的描述构造一个结构相同的类型，gob 按字段名匹配，因此可以与服务端的类型互相编解码
+func buildType(info *Orpc.TypeInfo) (reflect.Type, error) {
+	if t, ok := knownTypes[info.Name]; ok {
+		return t, nil
+	}
+	if info.Ref {
+		return nil, fmt.Errorf("recursive type %s is not supported with gob, use -codec json", info.Name)
+	}
+	if t, ok := basicTypes[info.Kind]; ok {
+		return t, nil
+	}
+	switch info.Kind {
+	case "ptr":
+		elem, err := buildType(info.Elem)
+		if err != nil {
+			return nil, err
+		}
+		return reflect.PointerTo(elem), nil
+	case "slice":
+		elem, err := buildType(info.Elem)
+		if err != nil {
+			return nil, err
+		}
+		return reflect.SliceOf(elem), nil
+	case "array":
+		elem, err := buildType(info.Elem)
+		if err != nil {
+			return nil, err
+		}
+		return reflect.ArrayOf(info.Len, elem), nil
+	case "map":
+		key, err := buildType(info.Key)
+		if err != nil {
+			return nil, err
+		}
+		elem, err := buildType(info.Elem)
+		if err != nil {
+			return nil, err
+		}
+		return reflect.MapOf(key, elem), nil
+	case "struct":
+		fields := make([]reflect.StructField, 0, len(info.Fields))
+		for _, f := range info.Fields {
+			t, err := buildType(f.Type)
+			if err != nil {
+				return nil, err
+			}
+			fields = append(fields, reflect.StructField{Name: f.Name, Type: t, Tag: reflect.StructTag(f.Tag)})
+		}
+		return reflect.StructOf(fields), nil
+	default:
+		return nil, fmt.Errorf("unsupported kind %s of type %s", info.Kind, info.Name)
+	}
+}
+
+// callDynamic 把 JSON 参数解码到按 m 构造的类型上，以 gob 编码调用，返回值可以直接编码为 JSON
+func callDynamic(ctx context.Context, client *Orpc.Client, method string, m *Orpc.MethodInfo, params string) (interface{}, error) {
+	argType, err := buildType(m.ArgType)
+	if err != nil {
+		return nil, err
+	}
+	replyType, err := buildType(m.ReplyType)
+	if err != nil {
+		return nil, err
+	}
+	//gob 不区分指针与值，统一发送值
+	for argType.Kind() == reflect.Ptr {
+		argType = argType.Elem()
+	}
+	argv := reflect.New(argType)
+	if err = json.Unmarshal([]byte(params), argv.Interface()); err != nil {
+		return nil, fmt.Errorf("invalid args for %s: %v", method, err)
+	}
+	replyv := reflect.New(replyType.Elem())
+	if err = client.Call(ctx, method, argv.Elem().Interface(), replyv.Interface()); err != nil {
+		return nil, err
+	}
+	return replyv.Interface(), nil
+}
+
+// typeString 类型在 list 中的显示形式
+func typeString(info *Orpc.TypeInfo) string {
+	if info.Name != "" {
+		return info.Name
+	}
+	switch info.Kind {
+	case "ptr":
+		return "*" + typeString(info.Elem)
+	case "slice":
+		return "[]" + typeString(info.Elem)
+	case "array":
+		return "[" + strconv.Itoa(info.Len) + "]" + typeString(info.Elem)
+	case "map":
+		return "map[" + typeString(info.Key) + "]" + typeString(info.Elem)
+	case "struct":
+		s := "struct{"
+		for i, f := range info.Fields {
+			if i > 0 {
+				s += "; "
+			}
+			s += f.Name + " " + typeString(f.Type)
+		}
+		return s + "}"
+	default:
+		return info.Kind
+	}
+}
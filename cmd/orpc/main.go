@@ -0,0 +1,280 @@
+// orpc 命令行客户端，用于临时调用 Orpc 服务而不必编写 main 包：
+//
+//	orpc call [flags] <addr> <Service.Method> [args]
+//	orpc list [flags] <addr> [Service]
+//	orpc describe [flags] <addr> <Service|Service.Method>
+//
+// addr 为 protocol@host:port，例如 tcp@127.0.0.1:9999，http@ 前缀表示通过 HTTP CONNECT 连接；
+// 使用 -registry 时省略 addr，从注册中心中随机选择一个提供该服务的实例。
+// args 与返回值均为 JSON，gob 编码时通过服务端的 Reflection 服务得到参数类型
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/R-Goys/Orpc/XClient"
+	"github.com/R-Goys/Orpc/codec"
+	Orpc "github.com/R-Goys/Orpc/server"
+)
+
+const usage = `usage:
+  orpc call [flags] <addr> <Service.Method> [args]
+  orpc list [flags] <addr> [Service]
+  orpc describe [flags] <addr> <Service|Service.Method>
+
+run "orpc <command> -h" for flags`
+
+type config struct {
+	codec          string
+	timeout        time.Duration
+	connectTimeout time.Duration
+	registry       string
+	verbose        bool
+}
+
+func main() {
+	if err := run(os.Args[1:], os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "orpc:", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return errors.New(usage)
+	}
+	var cfg config
+	fs := flag.NewFlagSet("orpc "+args[0], flag.ContinueOnError)
+	fs.StringVar(&cfg.codec, "codec", "gob", "codec used on the wire: gob or json")
+	fs.DurationVar(&cfg.timeout, "timeout", 5*time.Second, "timeout of the whole call")
+	fs.DurationVar(&cfg.connectTimeout, "connect-timeout", time.Second, "timeout of dialing the server")
+	fs.StringVar(&cfg.registry, "registry", "", "registry URL(s), comma separated; <addr> is omitted when set")
+	fs.BoolVar(&cfg.verbose, "v", false, "print Orpc logs to stderr")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	if !cfg.verbose {
+		log.SetOutput(io.Discard)
+	}
+	rest := fs.Args()
+
+	switch args[0] {
+	case "call":
+		addr, rest, err := target(&cfg, rest, 1, nameMethod)
+		if err != nil {
+			return err
+		}
+		params := "null"
+		if len(rest) > 1 {
+			params = rest[1]
+		}
+		return call(&cfg, addr, rest[0], params, out)
+	case "list":
+		addr, rest, err := target(&cfg, rest, 0, nameService)
+		if err != nil {
+			return err
+		}
+		service := ""
+		if len(rest) > 0 {
+			service = rest[0]
+		}
+		return list(&cfg, addr, service, out)
+	case "describe":
+		addr, rest, err := target(&cfg, rest, 1, nameEither)
+		if err != nil {
+			return err
+		}
+		return describe(&cfg, addr, rest[0], out)
+	default:
+		return errors.New(usage)
+	}
+}
+
+// 命令的第一个参数是服务名、Service.Method，还是两者都有可能（describe）
+const (
+	nameService = iota
+	nameMethod
+	nameEither
+)
+
+// splitMethod 与 Server.FindService 一致，在最后一个点处分开服务名与方法名，服务名本身可以包含点
+func splitMethod(serviceMethod string) (service, method string, ok bool) {
+	dot := strings.LastIndex(serviceMethod, ".")
+	if dot < 0 {
+		return serviceMethod, "", false
+	}
+	return serviceMethod[:dot], serviceMethod[dot+1:], true
+}
+
+// target 取出 args 中的地址，使用注册中心时从注册中心中选择，need 为地址之后至少需要的参数个数，kind 为 args[0] 的含义
+func target(cfg *config, args []string, need int, kind int) (string, []string, error) {
+	if cfg.registry == "" {
+		if len(args) < need+1 {
+			return "", nil, errors.New(usage)
+		}
+		return args[0], args[1:], nil
+	}
+	if len(args) < need || len(args) == 0 {
+		return "", nil, errors.New("a service name is required to pick an instance from the registry")
+	}
+	var services []string
+	if kind != nameMethod {
+		services = append(services, args[0])
+	}
+	if service, _, ok := splitMethod(args[0]); ok && kind != nameService {
+		services = append(services, service)
+	}
+	var err error
+	for _, service := range services {
+		d := XClient.NewOrpcServiceDiscovery(cfg.registry, service, 0)
+		var instance XClient.ServiceInstance
+		instance, err = d.Get(XClient.RandomSelect)
+		_ = d.Close()
+		if err == nil {
+			return instance.Addr, args, nil
+		}
+	}
+	if len(services) == 0 {
+		return "", nil, fmt.Errorf("invalid method %q, expect Service.Method", args[0])
+	}
+	return "", nil, fmt.Errorf("no instance of %s in the registry: %v", strings.Join(services, " or "), err)
+}
+
+func dial(cfg *config, addr string) (*Orpc.Client, error) {
+	var codecType codec.Type
+	switch cfg.codec {
+	case "gob":
+		codecType = codec.GobType
+	case "json":
+		codecType = codec.JsonType
+	default:
+		return nil, fmt.Errorf("unknown codec %q, expect gob or json", cfg.codec)
+	}
+	return Orpc.XDial(addr, &Orpc.Option{
+		CodecType:      codecType,
+		ConnectTimeOut: cfg.connectTimeout,
+		HandleTimeout:  cfg.timeout,
+	})
+}
+
+func call(cfg *config, addr, method, params string, out io.Writer) error {
+	client, err := dial(cfg, addr)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = client.Close() }()
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.timeout)
+	defer cancel()
+
+	var reply interface{}
+	if cfg.codec == "json" {
+		//JSON 编码时参数原样发送，返回值原样输出
+		var raw json.RawMessage
+		if err = client.Call(ctx, method, json.RawMessage(params), &raw); err != nil {
+			return err
+		}
+		reply = raw
+	} else {
+		m, err := lookupMethod(ctx, client, method)
+		if err != nil {
+			return err
+		}
+		if reply, err = callDynamic(ctx, client, method, m, params); err != nil {
+			return err
+		}
+	}
+	return printJSON(out, reply)
+}
+
+func reflectServices(ctx context.Context, client *Orpc.Client, service string) ([]Orpc.ServiceInfo, error) {
+	var resp Orpc.ReflectionResponse
+	if err := client.Call(ctx, Orpc.ReflectionListMethod, Orpc.ReflectionRequest{Service: service}, &resp); err != nil {
+		return nil, fmt.Errorf("reflection: %v", err)
+	}
+	return resp.Services, nil
+}
+
+func lookupMethod(ctx context.Context, client *Orpc.Client, serviceMethod string) (*Orpc.MethodInfo, error) {
+	service, method, ok := splitMethod(serviceMethod)
+	if !ok {
+		return nil, fmt.Errorf("invalid method %q, expect Service.Method", serviceMethod)
+	}
+	services, err := reflectServices(ctx, client, service)
+	if err != nil {
+		return nil, err
+	}
+	if len(services) == 0 {
+		return nil, fmt.Errorf("service not found: %s", service)
+	}
+	for _, m := range services[0].Methods {
+		if m.Name == method {
+			return &m, nil
+		}
+	}
+	return nil, fmt.Errorf("method not found: %s", serviceMethod)
+}
+
+func list(cfg *config, addr, service string, out io.Writer) error {
+	client, err := dial(cfg, addr)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = client.Close() }()
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.timeout)
+	defer cancel()
+	services, err := reflectServices(ctx, client, service)
+	if err != nil {
+		return err
+	}
+	for _, s := range services {
+		fmt.Fprintln(out, s.Name)
+		for _, m := range s.Methods {
+			fmt.Fprintf(out, "  %s(%s, %s) error\n", m.Name, typeString(m.ArgType), typeString(m.ReplyType))
+		}
+	}
+	return nil
+}
+
+func describe(cfg *config, addr, name string, out io.Writer) error {
+	client, err := dial(cfg, addr)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = client.Close() }()
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.timeout)
+	defer cancel()
+	//服务名可以包含点，先把整个名称当作服务，找不到时再当作 Service.Method
+	services, err := reflectServices(ctx, client, name)
+	if err == nil && len(services) > 0 {
+		return printJSON(out, services[0])
+	}
+	if !strings.Contains(name, ".") {
+		if err == nil {
+			err = fmt.Errorf("service not found: %s", name)
+		}
+		return err
+	}
+	m, err := lookupMethod(ctx, client, name)
+	if err != nil {
+		return err
+	}
+	return printJSON(out, m)
+}
+
+func printJSON(out io.Writer, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(out, string(data))
+	return err
+}
@@ -13,6 +13,7 @@ type Header struct {
 	ServiceMethod string `json:"ServiceMethod"`
 	Seq           uint64 `json:"Seq"`
 	Error         string `json:"Error"`
+	Priority      int    `json:"Priority,omitempty"` //请求的优先级，数值越大越优先，0 为默认优先级
 }
 
 type NewCodecFunc func(closer io.ReadWriteCloser) Codec
@@ -29,4 +30,5 @@ var NewCodecFuncMap map[Type]NewCodecFunc
 func init() {
 	NewCodecFuncMap = make(map[Type]NewCodecFunc)
 	NewCodecFuncMap[GobType] = NewGobCodec
+	NewCodecFuncMap[JsonType] = NewJsonCodec
 }
@@ -0,0 +1,63 @@
+package codec
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"log"
+)
+
+// JsonCodec 以换行分隔的 JSON 编码头部与请求体，便于没有 Go 类型的调用方（例如命令行工具）直接构造请求
+type JsonCodec struct {
+	conn io.ReadWriteCloser
+	buf  *bufio.Writer
+	dec  *json.Decoder
+	enc  *json.Encoder
+}
+
+var _ Codec = (*JsonCodec)(nil)
+
+func NewJsonCodec(conn io.ReadWriteCloser) Codec {
+	buf := bufio.NewWriter(conn)
+	return &JsonCodec{
+		conn: conn,
+		buf:  buf,
+		dec:  json.NewDecoder(conn),
+		enc:  json.NewEncoder(buf),
+	}
+}
+
+func (j JsonCodec) Close() error {
+	return j.conn.Close()
+}
+
+func (j JsonCodec) ReadHeader(header *Header) error {
+	return j.dec.Decode(header)
+}
+
+func (j JsonCodec) ReadBody(body interface{}) error {
+	if body == nil {
+		//与 gob 一致，body 为 nil 时丢弃请求体
+		var discard json.RawMessage
+		return j.dec.Decode(&discard)
+	}
+	return j.dec.Decode(body)
+}
+
+func (j JsonCodec) Write(Header *Header, body interface{}) error {
+	defer func() {
+		err := j.buf.Flush()
+		if err != nil {
+			_ = j.Close()
+		}
+	}()
+	if err := j.enc.Encode(Header); err != nil {
+		log.Println("rpc codec: json error encoding header ", err)
+		return err
+	}
+	if err := j.enc.Encode(body); err != nil {
+		log.Println("rpc codec: json error encoding body ", err)
+		return err
+	}
+	return nil
+}
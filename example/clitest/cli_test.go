@@ -0,0 +1,110 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http/httptest"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/R-Goys/Orpc/Registry"
+	Orpc "github.com/R-Goys/Orpc/server"
+)
+
+func _assert(condition bool, msg string, v ...interface{}) {
+	if !condition {
+		panic(fmt.Sprintf("assertion failed: "+msg, v...))
+	}
+}
+
+type Foo int
+
+type Args struct {
+	Num1, Num2 int
+	Tags       []string
+}
+
+type Result struct {
+	Sum  int
+	Tags map[string]bool
+}
+
+func (f Foo) Sum(args Args, reply *Result) error {
+	reply.Sum = args.Num1 + args.Num2
+	reply.Tags = make(map[string]bool)
+	for _, tag := range args.Tags {
+		reply.Tags[tag] = true
+	}
+	return nil
+}
+
+// build 编译 cmd 下的命令行工具
+func build(t *testing.T, name string) string {
+	bin := filepath.Join(t.TempDir(), name)
+	out, err := exec.Command("go", "build", "-o", bin, "github.com/R-Goys/Orpc/cmd/"+name).CombinedOutput()
+	if err != nil {
+		t.Fatalf("build %s: %v\n%s", name, err, out)
+	}
+	return bin
+}
+
+func orpc(t *testing.T, bin string, args ...string) string {
+	out, err := exec.Command(bin, args...).Output()
+	if err != nil {
+		if e, ok := err.(*exec.ExitError); ok {
+			t.Fatalf("orpc %v: %v\n%s", args, err, e.Stderr)
+		}
+		t.Fatal(err)
+	}
+	return string(out)
+}
+
+func Test_OrpcCLI(t *testing.T) {
+	bin := build(t, "orpc")
+	server := Orpc.NewServer()
+	_ = server.Register(new(Foo))
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	go server.Accept(l)
+	addr := "tcp@" + l.Addr().String()
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+
+	args := `{"Num1":1,"Num2":2,"Tags":["a"]}`
+	for _, target := range []string{addr, "http@" + strings.TrimPrefix(httpServer.URL, "http://")} {
+		for _, codec := range []string{"gob", "json"} {
+			var result Result
+			out := orpc(t, bin, "call", "-codec", codec, target, "Foo.Sum", args)
+			_assert(json.Unmarshal([]byte(out), &result) == nil, "invalid output %q", out)
+			_assert(result.Sum == 3 && result.Tags["a"], "unexpected result %+v via %s/%s", result, target, codec)
+		}
+	}
+
+	out := orpc(t, bin, "list", addr)
+	_assert(strings.Contains(out, "Foo\n  Sum(test.Args, *test.Result) error"), "unexpected list output %q", out)
+	var method Orpc.MethodInfo
+	out = orpc(t, bin, "describe", addr, "Foo.Sum")
+	_assert(json.Unmarshal([]byte(out), &method) == nil && len(method.ArgType.Fields) == 3, "unexpected describe output %q", out)
+
+	//通过注册中心找到实例
+	r := Registry.New(time.Minute)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+	_ = r.PutService("Foo", addr, nil)
+	out = orpc(t, bin, "call", "-registry", ts.URL, "Foo.Sum", args)
+	_assert(strings.Contains(out, `"Sum": 3`), "unexpected output %q", out)
+
+	//服务名中可以有点，方法名在最后一个点之后
+	_ = server.RegisterName("Primary.DB", new(Foo))
+	_ = r.PutService("Primary.DB", addr, nil)
+	out = orpc(t, bin, "call", "-registry", ts.URL, "Primary.DB.Sum", args)
+	_assert(strings.Contains(out, `"Sum": 3`), "unexpected output %q", out)
+	out = orpc(t, bin, "describe", addr, "Primary.DB.Sum")
+	_assert(json.Unmarshal([]byte(out), &method) == nil && method.Name == "Sum", "unexpected describe output %q", out)
+	var service Orpc.ServiceInfo
+	out = orpc(t, bin, "describe", "-registry", ts.URL, "Primary.DB")
+	_assert(json.Unmarshal([]byte(out), &service) == nil && service.Name == "Primary.DB", "unexpected describe output %q", out)
+}
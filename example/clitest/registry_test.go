@@ -0,0 +1,68 @@
+package test
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/R-Goys/Orpc/Registry"
+)
+
+func Test_RegistryCLI(t *testing.T) {
+	bin := build(t, "orpc-registry")
+	r := Registry.New(time.Minute)
+	ts := httptest.NewServer(r.Handler("/Orpc/registry"))
+	defer ts.Close()
+	registry := ts.URL + "/Orpc/registry"
+	_ = r.PutService("Foo", "tcp@127.0.0.1:1", map[string]string{"zone": "a", "owner": "x"})
+
+	out := orpc(t, bin, "list", "-registry", registry)
+	_assert(strings.Contains(out, "tcp@127.0.0.1:1") && strings.Contains(out, "owner=x") && strings.Contains(out, " ok "), "unexpected list output %q", out)
+
+	orpc(t, bin, "drain", "-registry", registry, "Foo", "tcp@127.0.0.1:1")
+	out = orpc(t, bin, "list", "-registry", registry, "Foo")
+	_assert(strings.Contains(out, "drained"), "expect instance to be drained: %q", out)
+	req, _ := http.NewRequest("GET", registry, nil)
+	req.Header.Set("X-Orpc-Service", "Foo")
+	resp, err := http.DefaultClient.Do(req)
+	_assert(err == nil && resp.Header.Get("X-Orpc-Servers") == "", "drained instance should not be routable")
+	_ = resp.Body.Close()
+	orpc(t, bin, "drain", "-registry", registry, "-undo", "Foo", "tcp@127.0.0.1:1")
+	out = orpc(t, bin, "list", "-registry", registry, "Foo")
+	_assert(!strings.Contains(out, "drained"), "expect instance to be routable again: %q", out)
+
+	//watch 打印后续的变更
+	cmd := exec.Command(bin, "watch", "-registry", registry, "Foo")
+	stdout, _ := cmd.StdoutPipe()
+	_assert(cmd.Start() == nil, "start watch")
+	defer func() { _ = cmd.Process.Kill(); _ = cmd.Wait() }()
+	lines := bufio.NewScanner(stdout)
+	_assert(lines.Scan() && strings.Contains(lines.Text(), "Foo tcp@127.0.0.1:1 ok"), "unexpected first line %q", lines.Text())
+
+	orpc(t, bin, "deregister", "-registry", registry, "Foo", "tcp@127.0.0.1:1")
+	_assert(lines.Scan() && strings.HasSuffix(lines.Text(), "- tcp@127.0.0.1:1"), "unexpected watch line %q", lines.Text())
+
+	//serve 启动一个可以被管理的注册中心
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	addr := l.Addr().String()
+	_ = l.Close()
+	server := exec.Command(bin, "serve", "-addr", addr, "-timeout", "0")
+	_assert(server.Start() == nil, "start serve")
+	defer func() { _ = server.Process.Kill(); _ = server.Wait() }()
+	registry = "http://" + addr + "/Orpc/registry"
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if resp, err := http.Post(registry+"/v1/services/Bar/instances", "application/json", strings.NewReader(`{"addr":"tcp@127.0.0.1:2"}`)); err == nil {
+			_ = resp.Body.Close()
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	out = orpc(t, bin, "list", "-registry", registry)
+	_assert(strings.Contains(out, "Bar") && strings.Contains(out, "tcp@127.0.0.1:2"), "unexpected list output %q", out)
+}
@@ -0,0 +1,118 @@
+package test
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/R-Goys/Orpc/XClient"
+)
+
+func _assert(condition bool, msg string, v ...interface{}) {
+	if !condition {
+		panic(fmt.Sprintf("assertion failed: "+msg, v...))
+	}
+}
+
+// fakeResolver 进程内的 DNS 替身
+type fakeResolver struct {
+	mu      sync.Mutex
+	srv     []*net.SRV
+	ips     []net.IPAddr
+	lookups int
+	down    bool //为 true 时解析一直阻塞到 ctx 结束，模拟 DNS 不可用
+}
+
+func (f *fakeResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	if err := f.wait(ctx); err != nil {
+		return "", nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return name, f.srv, nil
+}
+
+func (f *fakeResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	if err := f.wait(ctx); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.ips, nil
+}
+
+func (f *fakeResolver) wait(ctx context.Context) error {
+	f.mu.Lock()
+	f.lookups++
+	down := f.down
+	f.mu.Unlock()
+	if down {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	return nil
+}
+
+func Test_DNSSRV(t *testing.T) {
+	r := &fakeResolver{srv: []*net.SRV{
+		{Target: "a.example.com.", Port: 1, Priority: 10, Weight: 0},
+		{Target: "b.example.com.", Port: 2, Priority: 10, Weight: 100},
+		{Target: "c.example.com.", Port: 3, Priority: 20, Weight: 100},
+	}}
+	d := XClient.NewDNSSRVDiscovery(r, "_orpc._tcp.example.com", time.Minute)
+	defer func() { _ = d.Close() }()
+	for i := 0; i < 100; i++ {
+		s, err := d.Get(XClient.RandomSelect)
+		_assert(err == nil && s.Addr == "tcp@b.example.com:2", "unexpected server %v %v", s, err)
+	}
+	//备用优先级的 c 不会出现在 GetAll 中，XClient 的选择器与健康检查也就不会选中它
+	all, _ := d.GetAll()
+	_assert(len(all) == 2 && all[0].Addr == "tcp@a.example.com:1" && all[1].Addr == "tcp@b.example.com:2", "expect the top priority group but got %v", all)
+	_assert(r.lookups == 1, "expect cached result but looked up %d times", r.lookups)
+}
+
+func Test_DNSA(t *testing.T) {
+	r := &fakeResolver{ips: []net.IPAddr{{IP: net.ParseIP("10.0.0.1")}, {IP: net.ParseIP("::1")}}}
+	d := XClient.NewDNSDiscovery(r, "orpc.example.com", 9999, 50*time.Millisecond)
+	defer func() { _ = d.Close() }()
+	events := d.Watch()
+	all, _ := d.GetAll()
+	_assert(len(all) == 2 && all[0].Addr == "tcp@10.0.0.1:9999" && all[1].Addr == "tcp@[::1]:9999", "unexpected servers %v", all)
+	<-events
+	<-events
+
+	r.mu.Lock()
+	r.ips = r.ips[:1]
+	r.mu.Unlock()
+	select {
+	case e := <-events:
+		_assert(e.Type == XClient.ServerRemoved && e.Addr == "tcp@[::1]:9999", "unexpected event %v", e)
+	case <-time.After(time.Second):
+		t.Fatal("no removed event after ttl")
+	}
+}
+
+func Test_DNSDown(t *testing.T) {
+	r := &fakeResolver{ips: []net.IPAddr{{IP: net.ParseIP("10.0.0.1")}}}
+	d := XClient.NewDNSDiscovery(r, "orpc.example.com", 9999, 20*time.Millisecond)
+	defer func() { _ = d.Close() }()
+	s, err := d.Get(XClient.RoundRobinSelect)
+	_assert(err == nil && s.Addr == "tcp@10.0.0.1:9999", "unexpected server %v %v", s, err)
+
+	//DNS 不可用时 Get 与 GetAll 直接使用缓存，不等待解析
+	r.mu.Lock()
+	r.down = true
+	r.mu.Unlock()
+	time.Sleep(50 * time.Millisecond)
+	start := time.Now()
+	for i := 0; i < 10; i++ {
+		s, err = d.Get(XClient.RoundRobinSelect)
+		_assert(err == nil && s.Addr == "tcp@10.0.0.1:9999", "expect cached server, got %v %v", s, err)
+		all, _ := d.GetAll()
+		_assert(len(all) == 1, "expect cached servers, got %v", all)
+	}
+	_assert(time.Since(start) < 100*time.Millisecond, "Get should not wait for DNS, took %s", time.Since(start))
+}
@@ -0,0 +1,35 @@
+package gentest
+
+import (
+	"errors"
+	"time"
+)
+
+//go:generate go run github.com/R-Goys/Orpc/cmd/orpc-gen -types Arith
+
+type Arith int
+
+type Args struct{ A, B int }
+
+type Quotient struct{ Quo, Rem int }
+
+func (Arith) Add(args Args, reply *int) error {
+	*reply = args.A + args.B
+	return nil
+}
+
+func (*Arith) Divide(args *Args, reply *Quotient) error {
+	if args.B == 0 {
+		return errors.New("divide by zero")
+	}
+	reply.Quo, reply.Rem = args.A/args.B, args.A%args.B
+	return nil
+}
+
+func (Arith) Since(t time.Time, reply *time.Duration) error {
+	*reply = time.Since(t)
+	return nil
+}
+
+// Reset 不满足服务方法的签名，不会被生成
+func (Arith) Reset() {}
@@ -0,0 +1,95 @@
+package gentest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/R-Goys/Orpc/XClient"
+	Orpc "github.com/R-Goys/Orpc/server"
+)
+
+func _assert(condition bool, msg string, v ...interface{}) {
+	if !condition {
+		panic(fmt.Sprintf("assertion failed: "+msg, v...))
+	}
+}
+
+func Test_GeneratedClient(t *testing.T) {
+	server := Orpc.NewServer()
+	_ = server.Register(new(Arith))
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	go server.Accept(l)
+	addr := "tcp@" + l.Addr().String()
+	ctx := context.Background()
+
+	client, err := Orpc.XDial(addr)
+	_assert(err == nil, "dial error %v", err)
+	defer func() { _ = client.Close() }()
+	arith := NewArithClient(client)
+	sum, err := arith.Add(ctx, Args{A: 1, B: 2})
+	_assert(err == nil && sum == 3, "expect 3 but got %d %v", sum, err)
+	q, err := arith.Divide(ctx, &Args{A: 7, B: 2})
+	_assert(err == nil && q == Quotient{Quo: 3, Rem: 1}, "unexpected quotient %+v %v", q, err)
+	_, err = arith.Divide(ctx, &Args{A: 7})
+	_assert(err != nil && err.Error() == "divide by zero", "expect divide by zero but got %v", err)
+	d, err := arith.Since(ctx, time.Now().Add(-time.Hour))
+	_assert(err == nil && d >= time.Hour, "unexpected duration %v %v", d, err)
+
+	x := XClient.NewXClient(XClient.NewMultiServerDiscovery([]string{addr}), XClient.RandomSelect, nil)
+	defer func() { _ = x.Close() }()
+	sum, err = NewArithClient(x).Add(ctx, Args{A: 2, B: 2})
+	_assert(err == nil && sum == 4, "expect 4 but got %d %v", sum, err)
+}
+
+// Test_GeneratedUpToDate 重新生成一次，确认提交的 orpc_gen.go 与 arith.go 一致
+func Test_GeneratedUpToDate(t *testing.T) {
+	dir := t.TempDir()
+	src, _ := os.ReadFile("arith.go")
+	_ = os.WriteFile(filepath.Join(dir, "arith.go"), src, 0o644)
+	out, err := exec.Command("go", "run", "github.com/R-Goys/Orpc/cmd/orpc-gen", "-dir", dir, "-types", "Arith").CombinedOutput()
+	if err != nil {
+		t.Fatalf("orpc-gen: %v\n%s", err, out)
+	}
+	generated, _ := os.ReadFile(filepath.Join(dir, "orpc_gen.go"))
+	committed, _ := os.ReadFile("orpc_gen.go")
+	_assert(bytes.Equal(generated, committed), "orpc_gen.go is stale, run go generate")
+}
+
+// Test_ImportCollision 参数类型来自两个同名的包时，生成的代码为后一个包起别名，并且能够编译
+func Test_ImportCollision(t *testing.T) {
+	dir, err := os.MkdirTemp(".", "_collision")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+	src := `package collision
+
+import (
+	htmltemplate "html/template"
+	"text/template"
+)
+
+type Render int
+
+func (Render) HTML(args htmltemplate.HTML, reply *string) error { return nil }
+
+func (Render) Text(args string, reply *template.ExecError) error { return nil }
+`
+	_ = os.WriteFile(filepath.Join(dir, "render.go"), []byte(src), 0o644)
+	out, err := exec.Command("go", "run", "github.com/R-Goys/Orpc/cmd/orpc-gen", "-dir", dir).CombinedOutput()
+	if err != nil {
+		t.Fatalf("orpc-gen: %v\n%s", err, out)
+	}
+	generated, _ := os.ReadFile(filepath.Join(dir, "orpc_gen.go"))
+	_assert(bytes.Contains(generated, []byte(`template1 "text/template"`)), "expect an alias for the second template package:\n%s", generated)
+	if out, err = exec.Command("go", "build", "./"+dir).CombinedOutput(); err != nil {
+		t.Fatalf("generated code does not compile: %v\n%s\n%s", err, out, generated)
+	}
+}
@@ -0,0 +1,45 @@
+// Code generated by orpc-gen. DO NOT EDIT.
+
+package gentest
+
+import (
+	"context"
+	Orpc "github.com/R-Goys/Orpc/server"
+	"time"
+)
+
+// ArithService Arith 作为 Orpc 服务时提供的方法
+type ArithService interface {
+	Add(args Args, reply *int) error
+	Divide(args *Args, reply *Quotient) error
+	Since(args time.Time, reply *time.Duration) error
+}
+
+var _ ArithService = (*Arith)(nil)
+
+// ArithClient 以 Arith 服务的方法签名调用远端，c 可以是 *Orpc.Client 或者 *XClient.XClient
+type ArithClient struct {
+	c Orpc.Caller
+}
+
+func NewArithClient(c Orpc.Caller) *ArithClient {
+	return &ArithClient{c: c}
+}
+
+func (c *ArithClient) Add(ctx context.Context, args Args) (int, error) {
+	var reply int
+	err := c.c.Call(ctx, "Arith.Add", args, &reply)
+	return reply, err
+}
+
+func (c *ArithClient) Divide(ctx context.Context, args *Args) (Quotient, error) {
+	var reply Quotient
+	err := c.c.Call(ctx, "Arith.Divide", args, &reply)
+	return reply, err
+}
+
+func (c *ArithClient) Since(ctx context.Context, args time.Time) (time.Duration, error) {
+	var reply time.Duration
+	err := c.c.Call(ctx, "Arith.Since", args, &reply)
+	return reply, err
+}
@@ -0,0 +1,93 @@
+package test
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/R-Goys/Orpc/XClient"
+	Orpc "github.com/R-Goys/Orpc/server"
+)
+
+func _assert(condition bool, msg string, v ...interface{}) {
+	if !condition {
+		panic(fmt.Sprintf("assertion failed: "+msg, v...))
+	}
+}
+
+type Foo int
+
+// Addr 返回处理请求的 server 的地址
+func (f Foo) Addr(args int, reply *string) error {
+	*reply = addrs[int(f)]
+	return nil
+}
+
+var addrs = make(map[int]string)
+
+func serve(t *testing.T, id int) (*Orpc.Server, string) {
+	server := Orpc.NewServer()
+	_ = server.Register(Foo(id))
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Accept(l)
+	addrs[id] = "tcp@" + l.Addr().String()
+	return server, addrs[id]
+}
+
+func Test_HealthService(t *testing.T) {
+	server, addr := serve(t, 0)
+	_assert(len(server.Services()) == 1, "Health should not be listed in %v", server.Services())
+	client, err := Orpc.XDial(addr)
+	_assert(err == nil, "dial error %v", err)
+	defer func() { _ = client.Close() }()
+
+	ctx := context.Background()
+	for service, expect := range map[string]Orpc.ServingStatus{
+		"":    Orpc.StatusServing,
+		"Foo": Orpc.StatusServing,
+		"Bar": Orpc.StatusUnknown,
+	} {
+		status, err := client.CheckHealth(ctx, service)
+		_assert(err == nil && status == expect, "expect %q to be %v but got %v %v", service, expect, status, err)
+	}
+
+	//Watch 在状态变化时返回
+	watch := client.Go(Orpc.HealthWatchMethod, Orpc.HealthWatchRequest{Service: "Foo", Status: Orpc.StatusServing}, new(Orpc.HealthCheckResponse), nil)
+	time.Sleep(50 * time.Millisecond)
+	server.Health().SetServingStatus("Foo", Orpc.StatusNotServing)
+	watch = <-watch.Done
+	_assert(watch.Error == nil && watch.Reply.(*Orpc.HealthCheckResponse).Status == Orpc.StatusNotServing, "unexpected watch result %v", watch.Error)
+	server.Health().SetServingStatus("Foo", Orpc.StatusServing)
+
+	//开始优雅关闭后全部变为 NOT_SERVING
+	watch = client.Go(Orpc.HealthWatchMethod, Orpc.HealthWatchRequest{Status: Orpc.StatusServing}, new(Orpc.HealthCheckResponse), nil)
+	time.Sleep(50 * time.Millisecond)
+	shutdownCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	_ = server.Shutdown(shutdownCtx)
+	watch = <-watch.Done
+	_assert(watch.Error == nil && watch.Reply.(*Orpc.HealthCheckResponse).Status == Orpc.StatusNotServing, "unexpected watch result %v", watch.Error)
+	_assert(server.Health().Status("Foo") == Orpc.StatusNotServing, "expect NOT_SERVING during shutdown")
+}
+
+func Test_HealthAwareSelect(t *testing.T) {
+	sick, sickAddr := serve(t, 1)
+	_, okAddr := serve(t, 2)
+	d := XClient.NewMultiServerDiscovery([]string{sickAddr, okAddr})
+	x := XClient.NewXClient(d, XClient.RoundRobinSelect, nil)
+	defer func() { _ = x.Close() }()
+	x.EnableHealthCheck(50 * time.Millisecond)
+
+	sick.Health().SetServingStatus("", Orpc.StatusNotServing)
+	time.Sleep(200 * time.Millisecond)
+	for i := 0; i < 4; i++ {
+		var reply string
+		err := x.Call(context.Background(), "Foo.Addr", 0, &reply)
+		_assert(err == nil && reply == okAddr, "expect call to go to %s but got %s %v", okAddr, reply, err)
+	}
+}
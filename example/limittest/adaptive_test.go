@@ -0,0 +1,76 @@
+package test
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	Orpc "github.com/R-Goys/Orpc/server"
+)
+
+// adaptiveServer Work.Do 同时只能处理 capacity 个请求，超出的在方法内部排队
+func adaptiveServer(t *testing.T, capacity int, work time.Duration, l Orpc.AdaptiveLimit) (*Orpc.Server, string) {
+	server := Orpc.NewServer()
+	busy := make(chan struct{}, capacity)
+	_ = Orpc.Handle(server, "Work.Do", func(ctx context.Context, n int) (int, error) {
+		busy <- struct{}{}
+		time.Sleep(work)
+		<-busy
+		return n, nil
+	})
+	server.EnableAdaptiveLimit(l)
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Accept(lis)
+	t.Cleanup(func() { _ = server.Shutdown(context.Background()) })
+	return server, lis.Addr().String()
+}
+
+// load 用 callers 个并发调用方持续调用 d，返回被拒绝的次数
+func load(t *testing.T, addr string, callers int, d time.Duration) int64 {
+	client := dial(t, addr)
+	var shed atomic.Int64
+	var wg sync.WaitGroup
+	deadline := time.Now().Add(d)
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for time.Now().Before(deadline) {
+				_, err := call(client, "Work.Do", 1)
+				if Orpc.IsOverloaded(err) {
+					_assert(Orpc.IsRetryable(err), "overloaded should be retryable")
+					shed.Add(1)
+					time.Sleep(time.Millisecond)
+				} else {
+					_assert(err == nil, "unexpected error %v", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return shed.Load()
+}
+
+func Test_AdaptiveLimitShedsUnderQueueing(t *testing.T) {
+	server, addr := adaptiveServer(t, 4, 5*time.Millisecond, Orpc.AdaptiveLimit{InitialLimit: 40})
+	_assert(server.AdaptiveStats().Limit == 40, "expect initial limit 40 but got %+v", server.AdaptiveStats())
+	shed := load(t, addr, 40, 500*time.Millisecond)
+	stats := server.AdaptiveStats()
+	_assert(stats.Limit < 20, "limit should drop when requests queue up, got %+v", stats)
+	_assert(shed > 0 && stats.Shed == uint64(shed), "expect shed requests to be counted, got %d %+v", shed, stats)
+	_assert(stats.MinLatency >= 5*time.Millisecond, "unexpected min latency %+v", stats)
+}
+
+func Test_AdaptiveLimitGrows(t *testing.T) {
+	server, addr := adaptiveServer(t, 100, time.Millisecond, Orpc.AdaptiveLimit{InitialLimit: 4, MaxLimit: 8})
+	load(t, addr, 4, 300*time.Millisecond)
+	stats := server.AdaptiveStats()
+	_assert(stats.Limit > 4 && stats.Limit <= 8, "limit should grow without queueing, got %+v", stats)
+	_assert(stats.InFlight == 0, "no request should be in flight, got %+v", stats)
+}
@@ -0,0 +1,137 @@
+package test
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/R-Goys/Orpc/codec"
+	Orpc "github.com/R-Goys/Orpc/server"
+)
+
+func _assert(condition bool, msg string, v ...interface{}) {
+	if !condition {
+		panic(fmt.Sprintf("assertion failed: "+msg, v...))
+	}
+}
+
+// gate Slow.Wait 在 open 关闭前阻塞，每个开始执行的请求向 started 发送一次
+type gate struct {
+	started chan struct{}
+	open    chan struct{}
+}
+
+func serve(t *testing.T, limits Orpc.Limits) (*gate, string) {
+	g := &gate{started: make(chan struct{}, 16), open: make(chan struct{})}
+	server := Orpc.NewServer()
+	_ = Orpc.Handle(server, "Slow.Wait", func(ctx context.Context, n int) (int, error) {
+		g.started <- struct{}{}
+		<-g.open
+		return n, nil
+	})
+	_ = Orpc.Handle(server, "Slow.Echo", func(ctx context.Context, n int) (int, error) {
+		return n, nil
+	})
+	server.SetLimits(limits)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Accept(l)
+	t.Cleanup(func() { _ = server.Shutdown(context.Background()) })
+	return g, l.Addr().String()
+}
+
+func dial(t *testing.T, addr string) *Orpc.Client {
+	client, err := Orpc.Dial("tcp", addr, &Orpc.Option{CodecType: codec.GobType})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = client.Close() })
+	return client
+}
+
+func call(client *Orpc.Client, method string, n int) (int, error) {
+	return Orpc.CallTyped[int, int](context.Background(), client, method, n)
+}
+
+// goWait 异步调用 Slow.Wait，并等待它开始执行
+func goWait(g *gate, client *Orpc.Client, n int) *Orpc.Call {
+	c := client.Go("Slow.Wait", n, new(int), nil)
+	select {
+	case <-g.started:
+	case <-time.After(time.Second):
+		panic("Slow.Wait did not start")
+	}
+	return c
+}
+
+func Test_MaxInFlight(t *testing.T) {
+	g, addr := serve(t, Orpc.Limits{MaxInFlight: 2})
+	a, b := dial(t, addr), dial(t, addr)
+	first, second := goWait(g, a, 1), goWait(g, b, 2)
+	_, err := call(a, "Slow.Echo", 3)
+	_assert(Orpc.IsResourceExhausted(err), "expect resource exhausted but got %v", err)
+	close(g.open)
+	_assert((<-first.Done).Error == nil && (<-second.Done).Error == nil, "in-flight calls should succeed")
+	n, err := call(b, "Slow.Echo", 3)
+	_assert(err == nil && n == 3, "expect 3 after the limit is released but got %d %v", n, err)
+}
+
+func Test_MaxInFlightPerConn(t *testing.T) {
+	g, addr := serve(t, Orpc.Limits{MaxInFlightPerConn: 1})
+	a, b := dial(t, addr), dial(t, addr)
+	first := goWait(g, a, 1)
+	_, err := call(a, "Slow.Echo", 2)
+	_assert(Orpc.IsResourceExhausted(err), "expect resource exhausted on the same connection but got %v", err)
+	n, err := call(b, "Slow.Echo", 2)
+	_assert(err == nil && n == 2, "other connections should not be limited, got %d %v", n, err)
+	close(g.open)
+	_assert((<-first.Done).Error == nil, "in-flight call should succeed")
+}
+
+func Test_MethodLimit(t *testing.T) {
+	g, addr := serve(t, Orpc.Limits{Methods: map[string]int{"Slow.Wait": 1}})
+	client := dial(t, addr)
+	first := goWait(g, client, 1)
+	_, err := call(client, "Slow.Wait", 2)
+	_assert(Orpc.IsResourceExhausted(err), "expect resource exhausted but got %v", err)
+	n, err := call(client, "Slow.Echo", 3)
+	_assert(err == nil && n == 3, "other methods should not be limited, got %d %v", n, err)
+	close(g.open)
+	_assert((<-first.Done).Error == nil, "in-flight call should succeed")
+}
+
+func Test_WorkerPool(t *testing.T) {
+	g, addr := serve(t, Orpc.Limits{Workers: 1, QueueSize: 1})
+	client := dial(t, addr)
+	running := goWait(g, client, 1)
+	queued := client.Go("Slow.Wait", 2, new(int), nil)
+	//等待第二个请求进入队列
+	time.Sleep(50 * time.Millisecond)
+	_, err := call(client, "Slow.Echo", 3)
+	_assert(Orpc.IsResourceExhausted(err), "expect queue full but got %v", err)
+	close(g.open)
+	_assert((<-running.Done).Error == nil, "running call should succeed")
+	queued = <-queued.Done
+	_assert(queued.Error == nil && *queued.Reply.(*int) == 2, "queued call should run after the worker is free: %v", queued.Error)
+}
+
+func Test_Backpressure(t *testing.T) {
+	g, addr := serve(t, Orpc.Limits{MaxInFlight: 1, Backpressure: true})
+	a, b := dial(t, addr), dial(t, addr)
+	first := goWait(g, a, 1)
+	//超过上限的请求等待而不是被拒绝
+	echo := b.Go("Slow.Echo", 2, new(int), nil)
+	select {
+	case <-echo.Done:
+		t.Fatal("request should wait for the in-flight one")
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(g.open)
+	_assert((<-first.Done).Error == nil, "in-flight call should succeed")
+	echo = <-echo.Done
+	_assert(echo.Error == nil && *echo.Reply.(*int) == 2, "waiting call should succeed: %v", echo.Error)
+}
@@ -0,0 +1,109 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/R-Goys/Orpc/codec"
+	Orpc "github.com/R-Goys/Orpc/server"
+)
+
+func Test_PriorityFromContext(t *testing.T) {
+	server := Orpc.NewServer()
+	_ = Orpc.Handle(server, "Prio.Get", func(ctx context.Context, _ int) (Orpc.Priority, error) {
+		return Orpc.PriorityFromContext(ctx), nil
+	})
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	go server.Accept(l)
+	t.Cleanup(func() { _ = server.Shutdown(context.Background()) })
+	client := dial(t, l.Addr().String())
+	for _, p := range []Orpc.Priority{Orpc.PriorityLow, Orpc.PriorityNormal, Orpc.PriorityCritical} {
+		got, err := Orpc.CallTyped[int, Orpc.Priority](Orpc.WithPriority(context.Background(), p), client, "Prio.Get", 0)
+		_assert(err == nil && got == p, "expect priority %d but got %d %v", p, got, err)
+	}
+}
+
+func Test_PriorityScheduling(t *testing.T) {
+	g := &gate{started: make(chan struct{}, 1), open: make(chan struct{})}
+	var mu sync.Mutex
+	var order []int
+	server := Orpc.NewServer()
+	_ = Orpc.Handle(server, "Slow.Wait", func(ctx context.Context, n int) (int, error) {
+		g.started <- struct{}{}
+		<-g.open
+		return n, nil
+	})
+	_ = Orpc.Handle(server, "Slow.Record", func(ctx context.Context, n int) (int, error) {
+		mu.Lock()
+		order = append(order, n)
+		mu.Unlock()
+		return n, nil
+	})
+	server.SetLimits(Orpc.Limits{Workers: 1, QueueSize: 3})
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	go server.Accept(l)
+	t.Cleanup(func() { _ = server.Shutdown(context.Background()) })
+	client := dial(t, l.Addr().String())
+
+	running := goWait(g, client, 0)
+	//同一个连接上的请求按发送的顺序进入队列
+	send := func(n int, p Orpc.Priority) *Orpc.Call {
+		c := &Orpc.Call{ServiceMethod: "Slow.Record", Args: n, Reply: new(int), Done: make(chan *Orpc.Call, 1), Priority: p}
+		client.Send(c)
+		return c
+	}
+	low := send(1, Orpc.PriorityLow)
+	normal := send(2, Orpc.PriorityNormal)
+	high := send(3, Orpc.PriorityHigh)
+	//队列已满，critical 顶替优先级最低的 low
+	critical := send(4, Orpc.PriorityCritical)
+	shed := <-low.Done
+	_assert(Orpc.IsResourceExhausted(shed.Error) && strings.Contains(shed.Error.Error(), "higher priority"), "low priority request should be shed, got %v", shed.Error)
+	//没有比 low 更低的请求可以顶替
+	err := client.Call(Orpc.WithPriority(context.Background(), Orpc.PriorityLow), "Slow.Record", 5, new(int))
+	_assert(Orpc.IsResourceExhausted(err) && strings.Contains(err.Error(), "queue is full"), "expect queue full but got %v", err)
+
+	close(g.open)
+	for _, c := range []*Orpc.Call{running, normal, high, critical} {
+		select {
+		case c = <-c.Done:
+			_assert(c.Error == nil, "call %s %v should succeed: %v", c.ServiceMethod, c.Args, c.Error)
+		case <-time.After(time.Second):
+			t.Fatal("call did not finish")
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	_assert(len(order) == 3 && order[0] == 4 && order[1] == 3 && order[2] == 2, "expect higher priority first but got %v", order)
+}
+
+// Test_ShedOtherConnection 被顶替的请求属于一个已经停止发送的连接，它仍然会在连接关闭前收到响应
+func Test_ShedOtherConnection(t *testing.T) {
+	g, addr := serve(t, Orpc.Limits{Workers: 1, QueueSize: 1})
+	running := goWait(g, dial(t, addr), 0)
+
+	conn, err := net.Dial("tcp", addr)
+	_assert(err == nil, "dial error %v", err)
+	defer func() { _ = conn.Close() }()
+	_ = json.NewEncoder(conn).Encode(&Orpc.Option{MagicNumber: Orpc.MagicNumber, CodecType: codec.GobType})
+	cc := codec.NewGobCodec(conn)
+	_ = cc.Write(&codec.Header{ServiceMethod: "Slow.Echo", Seq: 1, Priority: int(Orpc.PriorityLow)}, 1)
+	time.Sleep(50 * time.Millisecond)
+	//不再发送请求，服务端读到 EOF 后等待已经读到的请求
+	_ = conn.(*net.TCPConn).CloseWrite()
+	time.Sleep(50 * time.Millisecond)
+
+	high := dial(t, addr).Go("Slow.Echo", 2, new(int), nil)
+	var h codec.Header
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	_assert(cc.ReadHeader(&h) == nil && cc.ReadBody(nil) == nil, "evicted request should get a response")
+	_assert(h.Seq == 1 && strings.Contains(h.Error, "higher priority"), "unexpected response %+v", h)
+
+	close(g.open)
+	_assert((<-running.Done).Error == nil && (<-high.Done).Error == nil, "other calls should succeed")
+}
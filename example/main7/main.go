@@ -56,15 +56,15 @@ func startServer(registryAddr string, wg *sync.WaitGroup) {
 	server := Orpc.NewServer()
 	//将结构体及其方法注册进去
 	_ = server.Register(&foo)
-	//心跳续约机制，0代表默认值
-	Registry.HeartBeat(registryAddr, "tcp@"+l.Addr().String(), 0)
+	//心跳续约机制，会把 server 上注册的全部服务发布到注册中心，0代表默认值
+	Registry.ServerHeartBeat(registryAddr, "tcp@"+l.Addr().String(), server, nil, 0)
 	wg.Done()
 	server.Accept(l)
 }
 
 func call(registry string) {
-	//创建一个服务发现和注册中心
-	d := XClient.NewOrpcRegisterDiscovery(registry, 0)
+	//创建一个服务发现和注册中心，只发现提供 Foo 服务的地址
+	d := XClient.NewOrpcServiceDiscovery(registry, "Foo", 0)
 	//Xclient是一切的入口，服务发现注册，请求的发送都是靠他，总的来说，他就是一个支持自动发现服务的客户端
 	xc := XClient.NewXClient(d, XClient.RandomSelect, nil)
 	defer func() { _ = xc.Close() }()
@@ -82,7 +82,7 @@ func call(registry string) {
 
 func broadcast(registry string) {
 	//同上，但是此处是调用所有的服务，选择第一个正常返回的值
-	d := XClient.NewOrpcRegisterDiscovery(registry, 0)
+	d := XClient.NewOrpcServiceDiscovery(registry, "Foo", 0)
 	xc := XClient.NewXClient(d, XClient.RandomSelect, nil)
 	defer func() { _ = xc.Close() }()
 	var wg sync.WaitGroup
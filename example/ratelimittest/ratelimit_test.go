@@ -0,0 +1,122 @@
+package test
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/R-Goys/Orpc/codec"
+	Orpc "github.com/R-Goys/Orpc/server"
+)
+
+func _assert(condition bool, msg string, v ...interface{}) {
+	if !condition {
+		panic(fmt.Sprintf("assertion failed: "+msg, v...))
+	}
+}
+
+func serve(t *testing.T) (*Orpc.Server, string) {
+	server := Orpc.NewServer()
+	echo := func(ctx context.Context, n int) (int, error) { return n, nil }
+	_ = Orpc.Handle(server, "Math.Expensive", echo)
+	_ = Orpc.Handle(server, "Math.Cheap", echo)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Accept(l)
+	t.Cleanup(func() { _ = server.Shutdown(context.Background()) })
+	return server, l.Addr().String()
+}
+
+func dial(t *testing.T, addr string, clientID string) *Orpc.Client {
+	opt := &Orpc.Option{CodecType: codec.GobType}
+	if clientID != "" {
+		opt.Metadata = map[string]string{"client-id": clientID}
+	}
+	client, err := Orpc.Dial("tcp", addr, opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = client.Close() })
+	return client
+}
+
+func call(client *Orpc.Client, method string) error {
+	_, err := Orpc.CallTyped[int, int](context.Background(), client, method, 1)
+	return err
+}
+
+func Test_MethodRateLimit(t *testing.T) {
+	server, addr := serve(t)
+	server.SetRateLimit("Math.Expensive", Orpc.RateLimit{Rate: 10, Burst: 2})
+	a, b := dial(t, addr, ""), dial(t, addr, "")
+	_assert(call(a, "Math.Expensive") == nil && call(b, "Math.Expensive") == nil, "burst should be allowed")
+	err := call(a, "Math.Expensive")
+	_assert(Orpc.IsRateLimited(err) && Orpc.IsRetryable(err), "expect rate limited but got %v", err)
+	_assert(strings.Contains(err.Error(), "retry after"), "expect a retry hint but got %v", err)
+	_assert(call(a, "Math.Cheap") == nil, "other methods should not be limited")
+	//令牌以每秒 10 个的速度恢复
+	time.Sleep(120 * time.Millisecond)
+	_assert(call(b, "Math.Expensive") == nil, "token should be refilled")
+
+	server.SetRateLimit("Math.Expensive", Orpc.RateLimit{})
+	for i := 0; i < 5; i++ {
+		_assert(call(a, "Math.Expensive") == nil, "limit should be removed")
+	}
+}
+
+func Test_ServiceRateLimit(t *testing.T) {
+	server, addr := serve(t)
+	server.SetRateLimit("Math", Orpc.RateLimit{Rate: 0.1, Burst: 2})
+	server.SetRateLimit("Math.Expensive", Orpc.RateLimit{Rate: 0.1, Burst: 1})
+	client := dial(t, addr, "")
+	_assert(call(client, "Math.Expensive") == nil, "first call should be allowed")
+	//方法的桶已经空了，被拒绝的请求不消耗服务的令牌
+	_assert(Orpc.IsRateLimited(call(client, "Math.Expensive")), "expect method limit")
+	_assert(call(client, "Math.Cheap") == nil, "service bucket should still have a token")
+	err := call(client, "Math.Cheap")
+	_assert(Orpc.IsRateLimited(err) && strings.Contains(err.Error(), "Math,"), "expect service limit but got %v", err)
+}
+
+func Test_PerClientRateLimit(t *testing.T) {
+	server, addr := serve(t)
+	server.SetRateLimit("Math.Expensive", Orpc.RateLimit{Rate: 0.1, Burst: 1, PerClient: true})
+	alice, alice2, bob := dial(t, addr, "alice"), dial(t, addr, "alice"), dial(t, addr, "bob")
+	_assert(call(alice, "Math.Expensive") == nil, "alice first call")
+	_assert(Orpc.IsRateLimited(call(alice2, "Math.Expensive")), "connections of the same client share a bucket")
+	_assert(call(bob, "Math.Expensive") == nil, "bob should have a separate bucket")
+
+	//没有 client-id 时按远端地址识别，本地的连接都来自 127.0.0.1
+	anon, anon2 := dial(t, addr, ""), dial(t, addr, "")
+	_assert(call(anon, "Math.Expensive") == nil, "anonymous first call")
+	_assert(Orpc.IsRateLimited(call(anon2, "Math.Expensive")), "same host should share a bucket")
+
+	server.SetClientID(func(info Orpc.ConnInfo) string { return info.RemoteAddr })
+	_assert(call(dial(t, addr, ""), "Math.Expensive") == nil, "custom client id should use the full address")
+}
+
+func Test_RejectedRequestKeepsToken(t *testing.T) {
+	server, addr := serve(t)
+	started, open := make(chan struct{}), make(chan struct{})
+	_ = Orpc.Handle(server, "Math.Block", func(ctx context.Context, n int) (int, error) {
+		close(started)
+		<-open
+		return n, nil
+	})
+	server.SetRateLimit("Math", Orpc.RateLimit{Rate: 0.1, Burst: 2})
+	server.SetLimits(Orpc.Limits{MaxInFlight: 1})
+	client := dial(t, addr, "")
+	block := client.Go("Math.Block", 1, new(int), nil)
+	<-started
+	err := call(client, "Math.Cheap")
+	_assert(Orpc.IsResourceExhausted(err), "expect resource exhausted but got %v", err)
+	close(open)
+	_assert((<-block.Done).Error == nil, "blocked call should succeed")
+	//被并发限制拒绝的请求把令牌还了回去
+	_assert(call(client, "Math.Cheap") == nil, "rejected request should not consume a token")
+	_assert(Orpc.IsRateLimited(call(client, "Math.Cheap")), "expect rate limited after the burst")
+}
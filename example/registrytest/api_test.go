@@ -0,0 +1,63 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/R-Goys/Orpc/Registry"
+)
+
+func _assert(condition bool, msg string, v ...interface{}) {
+	if !condition {
+		panic(fmt.Sprintf("assertion failed: "+msg, v...))
+	}
+}
+
+func do(t *testing.T, method, url, body string) (*http.Response, map[string]interface{}) {
+	req, _ := http.NewRequest(method, url, strings.NewReader(body))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var v map[string]interface{}
+	_ = json.NewDecoder(resp.Body).Decode(&v)
+	return resp, v
+}
+
+func Test_API(t *testing.T) {
+	r := Registry.New(time.Minute)
+	ts := httptest.NewServer(http.StripPrefix("/Orpc/registry", r.APIHandler()))
+	defer ts.Close()
+	base := ts.URL + "/Orpc/registry/v1/services"
+	addr := url.PathEscape("tcp@127.0.0.1:1")
+
+	resp, _ := do(t, "POST", base+"/Foo/instances", `{"addr":"tcp@127.0.0.1:1","zone":"a","tags":["x"]}`)
+	_assert(resp.StatusCode == http.StatusCreated, "expect 201 but got %d", resp.StatusCode)
+	resp, _ = do(t, "POST", base+"/Foo/instances", `{"addr":"tcp@127.0.0.1:1","zone":"b"}`)
+	_assert(resp.StatusCode == http.StatusOK, "expect 200 but got %d", resp.StatusCode)
+	resp, body := do(t, "POST", base+"/Foo/instances", `{"addr":"127.0.0.1:1"}`)
+	_assert(resp.StatusCode == http.StatusBadRequest && body["error"] != nil, "expect 400 with error body but got %d %v", resp.StatusCode, body)
+
+	_, body = do(t, "GET", base, "")
+	services := body["services"].([]interface{})
+	_assert(len(services) == 1 && services[0].(map[string]interface{})["name"] == "Foo", "unexpected services %v", body)
+	_, body = do(t, "GET", base+"/Foo/instances", "")
+	instances := body["instances"].([]interface{})
+	_assert(len(instances) == 1 && instances[0].(map[string]interface{})["zone"] == "b", "unexpected instances %v", body)
+
+	resp, _ = do(t, "PUT", base+"/Foo/instances/"+addr+"/heartbeat", "")
+	_assert(resp.StatusCode == http.StatusNoContent, "expect 204 but got %d", resp.StatusCode)
+	resp, _ = do(t, "DELETE", base+"/Foo/instances/"+addr, "")
+	_assert(resp.StatusCode == http.StatusNoContent, "expect 204 but got %d", resp.StatusCode)
+	resp, _ = do(t, "DELETE", base+"/Foo/instances/"+addr, "")
+	_assert(resp.StatusCode == http.StatusNotFound, "expect 404 but got %d", resp.StatusCode)
+	resp, _ = do(t, "PUT", base+"/Foo/instances/"+addr+"/heartbeat", "")
+	_assert(resp.StatusCode == http.StatusNotFound, "expect 404 but got %d", resp.StatusCode)
+}
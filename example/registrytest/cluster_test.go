@@ -0,0 +1,296 @@
+package test
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/R-Goys/Orpc/Registry"
+	"github.com/R-Goys/Orpc/XClient"
+	Orpc "github.com/R-Goys/Orpc/server"
+)
+
+type clusterNode struct {
+	url      string
+	registry *Registry.ORegistry
+	server   *http.Server
+}
+
+func (n *clusterNode) stop() {
+	_ = n.server.Close()
+	_ = n.registry.Close()
+}
+
+func startCluster(t *testing.T, size int) []*clusterNode {
+	listeners := make([]net.Listener, size)
+	peers := make([]string, size)
+	for i := range listeners {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		listeners[i] = l
+		peers[i] = "http://" + l.Addr().String() + "/Orpc/registry"
+	}
+	nodes := make([]*clusterNode, size)
+	for i, l := range listeners {
+		nodes[i] = serveNode(l, Registry.NewCluster(time.Minute, peers[i], peers), peers[i])
+	}
+	return nodes
+}
+
+func serveNode(l net.Listener, r *Registry.ORegistry, url string) *clusterNode {
+	server := &http.Server{Handler: r.Handler("/Orpc/registry")}
+	go server.Serve(l)
+	return &clusterNode{url: url, registry: r, server: server}
+}
+
+// waitLeader 等待 nodes 中选出一个被全部节点认可的 leader
+func waitLeader(nodes []*clusterNode) *clusterNode {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		leader := nodes[0].registry.Leader()
+		agreed := leader != ""
+		for _, n := range nodes[1:] {
+			agreed = agreed && n.registry.Leader() == leader
+		}
+		for _, n := range nodes {
+			if agreed && n.url == leader && n.registry.IsLeader() {
+				return n
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	return nil
+}
+
+// waitInstances 等待每个节点上 service 都有 count 个实例
+func waitInstances(t *testing.T, nodes []*clusterNode, service string, count int) bool {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		ok := true
+		for _, n := range nodes {
+			_, body := do(t, "GET", n.url+"/v1/services/"+service+"/instances", "")
+			instances, _ := body["instances"].([]interface{})
+			ok = ok && len(instances) == count
+		}
+		if ok {
+			return true
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	return false
+}
+
+func Test_Cluster(t *testing.T) {
+	nodes := startCluster(t, 3)
+	leader := waitLeader(nodes)
+	_assert(leader != nil, "no leader elected")
+
+	var follower *clusterNode
+	for _, n := range nodes {
+		if n != leader {
+			follower = n
+		}
+	}
+	//follower 收到的写请求被重定向到 leader
+	resp, _ := do(t, "POST", follower.url+"/v1/services/Foo/instances", `{"addr":"tcp@127.0.0.1:1"}`)
+	_assert(resp.StatusCode == http.StatusCreated, "expect 201 but got %d", resp.StatusCode)
+	_assert(waitInstances(t, nodes, "Foo", 1), "instance not replicated to every node")
+
+	//leader 下线后剩下的节点选出新的 leader，继续接受写请求
+	leader.stop()
+	var rest []*clusterNode
+	for _, n := range nodes {
+		if n != leader {
+			rest = append(rest, n)
+		}
+	}
+	defer func() {
+		for _, n := range rest {
+			n.stop()
+		}
+	}()
+	newLeader := waitLeader(rest)
+	_assert(newLeader != nil && newLeader != leader, "no new leader elected")
+	resp, _ = do(t, "POST", rest[0].url+"/v1/services/Foo/instances", `{"addr":"tcp@127.0.0.1:2"}`)
+	_assert(resp.StatusCode == http.StatusCreated, "expect 201 but got %d", resp.StatusCode)
+	_assert(waitInstances(t, rest, "Foo", 2), "instance not replicated after failover")
+
+	//旧协议的注销请求同样会被转发
+	req, _ := http.NewRequest("DELETE", rest[1].url, nil)
+	req.Header.Set("X-Orpc-Server", "tcp@127.0.0.1:1")
+	req.Header.Set("X-Orpc-Services", "Foo")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = res.Body.Close()
+	_assert(res.StatusCode == http.StatusOK, "expect 200 but got %d", res.StatusCode)
+	_assert(waitInstances(t, rest, "Foo", 1), "deregistration not replicated")
+}
+
+// waitServers 等待 d 发现 addr
+func waitServers(d XClient.Discovery, addr string) bool {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		servers, _ := d.GetAll()
+		if slices.ContainsFunc(servers, func(s XClient.ServiceInstance) bool { return s.Addr == addr }) {
+			return true
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	return false
+}
+
+func Test_ClusterHeartBeatFailover(t *testing.T) {
+	nodes := startCluster(t, 3)
+	leader := waitLeader(nodes)
+	_assert(leader != nil, "no leader elected")
+	var followers []*clusterNode
+	for _, n := range nodes {
+		if n != leader {
+			followers = append(followers, n)
+		}
+	}
+	defer func() {
+		for _, n := range followers {
+			n.stop()
+		}
+	}()
+	//心跳与服务发现都从 follower 开始，旧 leader 放在另一个列表的最前面
+	registries := strings.Join([]string{followers[0].url, leader.url, followers[1].url}, ",")
+	leaderFirst := strings.Join([]string{leader.url, followers[0].url, followers[1].url}, ",")
+
+	var foo Foo
+	server := Orpc.NewServer()
+	_assert(server.Register(&foo) == nil, "register Foo")
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	go server.Accept(l)
+	addr := "tcp@" + l.Addr().String()
+	h := Registry.ServerHeartBeat(registries, addr, server, nil, 100*time.Millisecond)
+	defer func() { _ = h.Stop(context.Background()) }()
+	legacy := Registry.HeartBeat(registries, "tcp@127.0.0.1:1", 100*time.Millisecond)
+	defer func() { _ = legacy.Stop(context.Background()) }()
+
+	all := XClient.NewOrpcRegisterDiscovery(registries, time.Minute)
+	d := XClient.NewOrpcServiceDiscovery(registries, "Foo", time.Minute)
+	xc := XClient.NewXClient(d, XClient.RandomSelect, nil)
+	defer func() { _ = xc.Close() }()
+	defer func() { _ = all.Close() }()
+	_assert(waitInstances(t, nodes, "Foo", 1), "heartbeat sent to a follower not replicated")
+	_assert(waitServers(all, "tcp@127.0.0.1:1"), "legacy heartbeat sent to a follower not replicated")
+	_assert(waitServers(d, addr), "Foo not discovered")
+	var reply int
+	_assert(xc.Call(context.Background(), "Foo.Sleep", &Args{Num1: 1, Num2: 2}, &reply) == nil && reply == 3, "call Foo")
+
+	//leader 下线后心跳跟随重定向到新的 leader，新注册的服务照常发布
+	leader.stop()
+	failover := time.Now()
+	newLeader := waitLeader(followers)
+	_assert(newLeader != nil, "no new leader elected")
+	_assert(server.RegisterName("Bar", &foo) == nil, "register Bar")
+	_assert(waitInstances(t, followers, "Bar", 1), "heartbeat not committed after failover")
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) && !(h.Status().Healthy && h.Status().LastSuccess.After(failover) && legacy.Status().LastSuccess.After(failover)) {
+		time.Sleep(20 * time.Millisecond)
+	}
+	_assert(h.Status().Healthy && legacy.Status().LastSuccess.After(failover), "heartbeats unhealthy after failover: %+v %+v", h.Status(), legacy.Status())
+
+	//以旧 leader 开头的列表同样可以发现服务
+	bar := XClient.NewOrpcServiceDiscovery(leaderFirst, "Bar", time.Minute)
+	barClient := XClient.NewXClient(bar, XClient.RandomSelect, nil)
+	defer func() { _ = barClient.Close() }()
+	_assert(waitServers(bar, addr), "Bar not discovered through the remaining registries")
+	_assert(barClient.Call(context.Background(), "Bar.Sleep", &Args{Num1: 1, Num2: 2}, &reply) == nil && reply == 3, "call Bar")
+	_assert(xc.Call(context.Background(), "Foo.Sleep", &Args{Num1: 1, Num2: 2}, &reply) == nil, "call Foo after failover")
+
+	//注销同样经由 follower 转发到新的 leader
+	_assert(server.Unregister("Bar") == nil, "unregister Bar")
+	_assert(waitInstances(t, followers, "Bar", 0), "deregistration not committed after failover")
+}
+
+func Test_ClusterRestart(t *testing.T) {
+	listeners := make([]net.Listener, 3)
+	peers := make([]string, 3)
+	dirs := make([]string, 3)
+	for i := range listeners {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		listeners[i], peers[i], dirs[i] = l, "http://"+l.Addr().String()+"/Orpc/registry", t.TempDir()
+	}
+	nodes := make([]*clusterNode, 3)
+	for i, l := range listeners {
+		r, err := Registry.OpenCluster(time.Minute, dirs[i], peers[i], peers)
+		_assert(err == nil, "open cluster %v", err)
+		nodes[i] = serveNode(l, r, peers[i])
+	}
+	defer func() {
+		for _, n := range nodes {
+			n.stop()
+		}
+	}()
+	leader := waitLeader(nodes)
+	_assert(leader != nil, "no leader elected")
+	resp, _ := do(t, "POST", leader.url+"/v1/services/Foo/instances", `{"addr":"tcp@127.0.0.1:1"}`)
+	_assert(resp.StatusCode == http.StatusCreated, "expect 201 but got %d", resp.StatusCode)
+	_assert(waitInstances(t, nodes, "Foo", 1), "instance not replicated to every node")
+
+	//leader 以原来的地址重启，从磁盘恢复 Raft 状态后重新加入集群
+	i := slices.Index(nodes, leader)
+	leader.stop()
+	l, err := net.Listen("tcp", listeners[i].Addr().String())
+	if err != nil {
+		t.Skip("address not reusable:", err)
+	}
+	r, err := Registry.OpenCluster(time.Minute, dirs[i], peers[i], peers)
+	_assert(err == nil, "reopen cluster %v", err)
+	nodes[i] = serveNode(l, r, peers[i])
+	_assert(waitLeader(nodes) != nil, "no leader after restart")
+	_assert(waitInstances(t, nodes, "Foo", 1), "restarted node lost the instance")
+	resp, _ = do(t, "POST", nodes[i].url+"/v1/services/Foo/instances", `{"addr":"tcp@127.0.0.1:2"}`)
+	_assert(resp.StatusCode == http.StatusCreated, "expect 201 but got %d", resp.StatusCode)
+	_assert(waitInstances(t, nodes, "Foo", 2), "instance not replicated after restart")
+}
+
+func Test_ClusterNoLeaderError(t *testing.T) {
+	nodes := startCluster(t, 3)
+	leader := waitLeader(nodes)
+	_assert(leader != nil, "no leader elected")
+	//只剩一个节点时选不出 leader
+	var last *clusterNode
+	for _, n := range nodes {
+		if last == nil && n != leader {
+			last = n
+			continue
+		}
+		n.stop()
+	}
+	defer last.stop()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) && last.registry.Leader() != "" {
+		time.Sleep(20 * time.Millisecond)
+	}
+	_assert(last.registry.Leader() == "", "expect no leader")
+
+	//JSON API 返回带原因的 JSON 错误
+	resp, body := do(t, "POST", last.url+"/v1/services/Foo/instances", `{"addr":"tcp@127.0.0.1:1"}`)
+	_assert(resp.StatusCode == http.StatusServiceUnavailable && body["error"] == "no leader", "unexpected response %d %v", resp.StatusCode, body)
+	_assert(strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json"), "expect JSON error")
+
+	//旧的 header 协议仍然是纯文本
+	req, _ := http.NewRequest("POST", last.url, nil)
+	req.Header.Set("X-Orpc-Server", "tcp@127.0.0.1:1")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = res.Body.Close()
+	_assert(res.StatusCode == http.StatusServiceUnavailable && strings.HasPrefix(res.Header.Get("Content-Type"), "text/plain"), "unexpected legacy response %d %s", res.StatusCode, res.Header.Get("Content-Type"))
+}
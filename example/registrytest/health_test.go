@@ -0,0 +1,92 @@
+package test
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/R-Goys/Orpc/Registry"
+	Orpc "github.com/R-Goys/Orpc/server"
+)
+
+func serve(t *testing.T, rcvrs ...interface{}) (*Orpc.Server, string) {
+	server := Orpc.NewServer()
+	for _, rcvr := range rcvrs {
+		_ = server.Register(rcvr)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Accept(l)
+	return server, "tcp@" + l.Addr().String()
+}
+
+func Test_HealthCheck(t *testing.T) {
+	r := Registry.New(time.Minute)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+	defer func() { _ = r.Close() }()
+
+	var foo Foo
+	server, checked := serve(t, &foo)
+	legacy, legacyAddr := serve(t, &foo)
+	//没有 Health 服务的实例只要能响应请求就是健康的
+	legacy.DisableHealth()
+	//只完成 TCP 握手、从不处理请求的实例
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer l.Close()
+	wedged := "tcp@" + l.Addr().String()
+	for _, addr := range []string{checked, legacyAddr, wedged} {
+		_, _ = r.Register(Registry.ServerItem{Service: "Foo", Addr: addr})
+	}
+	r.EnableHealthCheck(50*time.Millisecond, 2)
+
+	servers := func() string {
+		req, _ := http.NewRequest("GET", ts.URL, nil)
+		req.Header.Set("X-Orpc-Service", "Foo")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = resp.Body.Close()
+		return resp.Header.Get("X-Orpc-Servers")
+	}
+	waitServers := func(expect string) bool {
+		deadline := time.Now().Add(3 * time.Second)
+		for time.Now().Before(deadline) {
+			if servers() == expect {
+				return true
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		return false
+	}
+	healthy := []string{checked, legacyAddr}
+	if healthy[0] > healthy[1] {
+		healthy[0], healthy[1] = healthy[1], healthy[0]
+	}
+	_assert(waitServers(healthy[0]+","+healthy[1]), "wedged instance should be marked unhealthy, got %q", servers())
+
+	//JSON 协议中仍然能看到不健康的实例
+	req, _ := http.NewRequest("GET", ts.URL, nil)
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("X-Orpc-Service", "Foo")
+	resp, err := http.DefaultClient.Do(req)
+	_assert(err == nil, "get error %v", err)
+	var list struct{ Instances []Registry.ServerItem }
+	_ = json.NewDecoder(resp.Body).Decode(&list)
+	_ = resp.Body.Close()
+	_assert(len(list.Instances) == 3, "expect 3 instances but got %d", len(list.Instances))
+	for _, s := range list.Instances {
+		_assert(s.Unhealthy == (s.Addr == wedged), "unexpected health of %+v", s)
+	}
+
+	server.Health().SetServingStatus("Foo", Orpc.StatusNotServing)
+	_assert(waitServers(legacyAddr), "NOT_SERVING instance should be marked unhealthy, got %q", servers())
+	server.Health().SetServingStatus("Foo", Orpc.StatusServing)
+	_assert(waitServers(healthy[0]+","+healthy[1]), "instance should recover, got %q", servers())
+}
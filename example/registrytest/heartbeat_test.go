@@ -0,0 +1,128 @@
+package test
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/R-Goys/Orpc/Registry"
+	Orpc "github.com/R-Goys/Orpc/server"
+)
+
+type Foo int
+
+type Args struct{ Num1, Num2 int }
+
+func (f Foo) Sleep(args Args, reply *int) error {
+	time.Sleep(time.Duration(args.Num1) * time.Millisecond)
+	*reply = args.Num1 + args.Num2
+	return nil
+}
+
+func Test_ShutdownDeregister(t *testing.T) {
+	r := Registry.New(time.Minute)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	var foo Foo
+	server := Orpc.NewServer()
+	_ = server.Register(&foo)
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	go server.Accept(l)
+	addr := "tcp@" + l.Addr().String()
+	Registry.ServerHeartBeat(ts.URL, addr, server, nil, time.Second)
+	_assert(len(r.Services["Foo"]) == 1, "expect Foo to be registered")
+
+	client, err := Orpc.XDial(addr)
+	_assert(err == nil, "dial error %v", err)
+	defer func() { _ = client.Close() }()
+	call := client.Go("Foo.Sleep", &Args{Num1: 200, Num2: 1}, new(int), nil)
+	time.Sleep(50 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_assert(server.Shutdown(ctx) == nil, "shutdown should finish in time")
+	_assert(len(r.Services["Foo"]) == 0, "expect Foo to be deregistered")
+	call = <-call.Done
+	_assert(call.Error == nil && *call.Reply.(*int) == 201, "in-flight call should finish: %v", call.Error)
+	_, err = Orpc.XDial(addr)
+	_assert(err != nil, "expect dial to fail after shutdown")
+}
+
+// flakyRegistry 在 fail 为 true 时对所有请求返回 503，可以替换 registry 模拟注册中心重启
+type flakyRegistry struct {
+	mu       sync.Mutex
+	fail     bool
+	registry *Registry.ORegistry
+}
+
+func (f *flakyRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	f.mu.Lock()
+	fail, r := f.fail, f.registry
+	f.mu.Unlock()
+	if fail {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	r.ServeHTTP(w, req)
+}
+
+func Test_HeartBeatRetry(t *testing.T) {
+	f := &flakyRegistry{registry: Registry.New(time.Minute)}
+	ts := httptest.NewServer(f)
+	defer ts.Close()
+
+	h := Registry.HeartBeat(ts.URL, "tcp@127.0.0.1:1", 200*time.Millisecond)
+	defer func() { _ = h.Stop(context.Background()) }()
+	_assert(h.Status().Healthy, "first heartbeat should succeed")
+	changes := make(chan Registry.HeartBeatStatus, 10)
+	h.OnStatusChange(func(s Registry.HeartBeatStatus) { changes <- s })
+
+	//注册中心不可用，随后以空的状态重启
+	f.mu.Lock()
+	f.fail = true
+	f.mu.Unlock()
+	s := <-changes
+	_assert(!s.Healthy && s.LastError != nil, "expect unhealthy status but got %+v", s)
+	restarted := Registry.New(time.Minute)
+	f.mu.Lock()
+	f.fail, f.registry = false, restarted
+	f.mu.Unlock()
+	s = <-changes
+	_assert(s.Healthy && s.ConsecutiveFailures == 0, "expect recovered status but got %+v", s)
+	_assert(restarted.Revision() == 1, "expect re-registration after restart")
+}
+
+func Test_StopDeadline(t *testing.T) {
+	var mu sync.Mutex
+	requests := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		requests++
+		n := requests
+		mu.Unlock()
+		if n > 1 {
+			//第一次心跳之后注册中心不再响应
+			select {
+			case <-req.Context().Done():
+			case <-time.After(10 * time.Second):
+			}
+		}
+	}))
+	defer ts.Close()
+	defer ts.CloseClientConnections()
+
+	h := Registry.HeartBeat(ts.URL, "tcp@127.0.0.1:1", 20*time.Millisecond)
+	time.Sleep(100 * time.Millisecond)
+	//正在发送的心跳被中断，注销请求遵守 ctx 的期限
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := h.Stop(ctx)
+	_assert(err != nil && time.Since(start) < time.Second, "expect Stop to honour the deadline, got %v after %s", err, time.Since(start))
+	_assert(h.Status().Healthy, "an interrupted heartbeat should not mark the handle unhealthy")
+}
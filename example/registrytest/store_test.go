@@ -0,0 +1,31 @@
+package test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/R-Goys/Orpc/Registry"
+)
+
+func Test_Persistence(t *testing.T) {
+	dir := t.TempDir()
+	r, err := Registry.Open(time.Minute, dir)
+	_assert(err == nil, "open error %v", err)
+	r.PutService("Foo", "tcp@127.0.0.1:1", map[string]string{"zone": "a"})
+	r.PutService("Foo", "tcp@127.0.0.1:2", nil)
+	r.Deregister("Foo", "tcp@127.0.0.1:2")
+	rev := r.Revision()
+	//不调用 Close，模拟进程崩溃后只剩下日志
+
+	r, err = Registry.Open(time.Minute, dir)
+	_assert(err == nil, "reopen error %v", err)
+	defer func() { _ = r.Close() }()
+	_assert(r.Revision() == rev, "expect revision %d but got %d", rev, r.Revision())
+	items := r.Services["Foo"]
+	_assert(len(items) == 1, "expect 1 restored instance but got %d", len(items))
+	item := items["tcp@127.0.0.1:1"]
+	_assert(item.Zone == "a" && item.Unverified, "unexpected restored instance %+v", item)
+
+	r.PutService("Foo", "tcp@127.0.0.1:1", nil)
+	_assert(!item.Unverified && r.Revision() == rev+1, "heartbeat should verify the instance")
+}
@@ -0,0 +1,87 @@
+package test
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/R-Goys/Orpc/Registry"
+	Orpc "github.com/R-Goys/Orpc/server"
+)
+
+func Test_RegisterNameUnregister(t *testing.T) {
+	r := Registry.New(time.Minute)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+	defer func() { _ = r.Close() }()
+
+	var primary, replica Foo
+	server := Orpc.NewServer()
+	_assert(server.RegisterName("Primary", &primary) == nil, "register Primary")
+	_assert(server.RegisterName("Replica", &replica) == nil, "register Replica")
+	_assert(server.RegisterName("Primary", &replica) != nil, "expect duplicate name to fail")
+	_assert(server.RegisterName("lower", &replica) != nil, "expect unexported name to fail")
+	_assert(server.Unregister("Missing") != nil, "expect unknown service to fail")
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	go server.Accept(l)
+	addr := "tcp@" + l.Addr().String()
+	h := Registry.ServerHeartBeat(ts.URL, addr, server, nil, time.Minute)
+	defer func() { _ = h.Stop(context.Background()) }()
+
+	servers := func(service string) string {
+		req, _ := http.NewRequest("GET", ts.URL, nil)
+		req.Header.Set("X-Orpc-Service", service)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = resp.Body.Close()
+		return resp.Header.Get("X-Orpc-Servers")
+	}
+	wait := func(service, expect string) bool {
+		deadline := time.Now().Add(2 * time.Second)
+		for time.Now().Before(deadline) {
+			if servers(service) == expect {
+				return true
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		return false
+	}
+	_assert(servers("Primary") == addr && servers("Replica") == addr, "expect both names to be published")
+
+	client, err := Orpc.XDial(addr)
+	_assert(err == nil, "dial error %v", err)
+	defer func() { _ = client.Close() }()
+	var reply int
+	_assert(client.Call(context.Background(), "Replica.Sleep", &Args{Num1: 1, Num2: 2}, &reply) == nil && reply == 3, "call Replica")
+
+	//Unregister 等待正在处理的请求结束，同时拒绝新的请求
+	slow := client.Go("Primary.Sleep", &Args{Num1: 300, Num2: 1}, new(int), nil)
+	time.Sleep(50 * time.Millisecond)
+	unregistered := make(chan struct{})
+	start := time.Now()
+	go func() {
+		_assert(server.Unregister("Primary") == nil, "unregister Primary")
+		close(unregistered)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	err = client.Call(context.Background(), "Primary.Sleep", &Args{Num1: 1}, &reply)
+	_assert(err != nil && err.Error() == "rpc server: service not found: Primary", "expect new calls to be rejected, got %v", err)
+	<-unregistered
+	_assert(time.Since(start) >= 200*time.Millisecond, "Unregister should wait for the in-flight call")
+	slow = <-slow.Done
+	_assert(slow.Error == nil && *slow.Reply.(*int) == 301, "in-flight call should finish: %v", slow.Error)
+
+	_assert(wait("Primary", ""), "expect Primary to be deregistered without waiting for the next heartbeat")
+	_assert(servers("Replica") == addr, "Replica should stay registered")
+	_assert(client.Call(context.Background(), "Replica.Sleep", &Args{Num1: 1, Num2: 2}, &reply) == nil, "Replica should keep serving")
+
+	//重新注册的服务同样立即发布
+	_assert(server.RegisterName("Primary", &primary) == nil, "register Primary again")
+	_assert(wait("Primary", addr), "expect Primary to be published again")
+	_assert(client.Call(context.Background(), "Primary.Sleep", &Args{Num1: 1, Num2: 2}, &reply) == nil, "Primary should serve again")
+}
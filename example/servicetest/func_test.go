@@ -0,0 +1,71 @@
+package t_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/R-Goys/Orpc/server"
+)
+
+type Pair struct{ A, B int }
+
+func Test_RegisterFunc(t *testing.T) {
+	server := Orpc.NewServer()
+	offset := 10
+	err := server.RegisterFunc("Math.Add", func(ctx context.Context, args Pair, reply *int) error {
+		*reply = args.A + args.B + offset
+		return nil
+	})
+	_assert(err == nil, "register func error %v", err)
+	err = Orpc.Handle(server, "Math.Div", func(ctx context.Context, args Pair) (float64, error) {
+		if args.B == 0 {
+			return 0, errors.New("divide by zero")
+		}
+		return float64(args.A) / float64(args.B), nil
+	})
+	_assert(err == nil, "handle error %v", err)
+	err = Orpc.Handle(server, "Math.Wait", func(ctx context.Context, d time.Duration) (string, error) {
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(d):
+			return "done", nil
+		}
+	})
+	_assert(err == nil, "handle error %v", err)
+
+	//与 Register 相同的签名规则
+	_assert(server.RegisterFunc("Math.Add", func(ctx context.Context, args Pair, reply *int) error { return nil }) != nil, "duplicate method should fail")
+	_assert(server.RegisterFunc("Math.Bad", func(args Pair, reply *int) error { return nil }) != nil, "missing ctx should fail")
+	_assert(server.RegisterFunc("Math.Bad", func(ctx context.Context, args Pair, reply int) error { return nil }) != nil, "non-pointer reply should fail")
+	var regErr *Orpc.RegistrationError
+	_assert(errors.As(server.RegisterFunc("Math.Nil", nil), &regErr), "nil func should fail with RegistrationError")
+	var nilFn func(ctx context.Context, args Pair, reply *int) error
+	_assert(errors.As(server.RegisterFunc("Math.Nil", nilFn), &regErr), "typed nil func should fail with RegistrationError")
+	_assert(errors.As(server.RegisterFunc("Math.Nil", 42), &regErr), "non-func should fail with RegistrationError")
+	_assert(server.RegisterFunc("Math.bad", func(ctx context.Context, args Pair, reply *int) error { return nil }) != nil, "unexported method should fail")
+	_assert(server.RegisterFunc("Foo.Add", func(ctx context.Context, args Pair, reply *int) error { return nil }) == nil, "new func service should succeed")
+	_assert(server.Register(new(Foo)) != nil, "Register should not override a func service")
+	_assert(len(server.Services()) == 2, "expect Foo and Math but got %v", server.Services())
+
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	go server.Accept(l)
+	client, err := Orpc.XDial("tcp@"+l.Addr().String(), &Orpc.Option{CodecType: "application/gob", HandleTimeout: 100 * time.Millisecond})
+	_assert(err == nil, "dial error %v", err)
+	defer func() { _ = client.Close() }()
+	ctx := context.Background()
+
+	sum, err := Orpc.CallTyped[Pair, int](ctx, client, "Math.Add", Pair{1, 2})
+	_assert(err == nil && sum == 13, "expect 13 but got %d %v", sum, err)
+	quo, err := Orpc.CallTyped[Pair, float64](ctx, client, "Math.Div", Pair{1, 4})
+	_assert(err == nil && quo == 0.25, "expect 0.25 but got %v %v", quo, err)
+	_, err = Orpc.CallTyped[Pair, float64](ctx, client, "Math.Div", Pair{1, 0})
+	_assert(err != nil && err.Error() == "divide by zero", "expect divide by zero but got %v", err)
+	done, err := Orpc.CallTyped[time.Duration, string](ctx, client, "Math.Wait", time.Millisecond)
+	_assert(err == nil && done == "done", "unexpected wait result %q %v", done, err)
+	_, err = Orpc.CallTyped[time.Duration, string](ctx, client, "Math.Wait", time.Minute)
+	_assert(err != nil, "expect handle timeout")
+}
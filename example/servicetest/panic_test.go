@@ -0,0 +1,64 @@
+package t_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/R-Goys/Orpc/server"
+)
+
+type Crash int
+
+func (Crash) Index(args []int, reply *int) error {
+	*reply = args[len(args)] //越界 panic
+	return nil
+}
+
+func (Crash) Len(args []int, reply *int) error {
+	*reply = len(args)
+	return nil
+}
+
+func Test_PanicRecovery(t *testing.T) {
+	server := Orpc.NewServer()
+	_ = server.Register(new(Crash))
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	go server.Accept(l)
+	client, err := Orpc.XDial("tcp@"+l.Addr().String(), &Orpc.Option{CodecType: "application/gob", HandleTimeout: time.Second})
+	_assert(err == nil, "dial error %v", err)
+	defer func() { _ = client.Close() }()
+	ctx := context.Background()
+
+	var reply int
+	err = client.Call(ctx, "Crash.Index", []int{1, 2}, &reply)
+	_assert(Orpc.IsInternalError(err), "expect internal error but got %v", err)
+	_assert(err.Error() == Orpc.ErrInternal+": panic in Crash.Index", "unexpected error %q", err)
+	//同一个连接上的其他请求不受影响
+	err = client.Call(ctx, "Crash.Len", []int{1, 2}, &reply)
+	_assert(err == nil && reply == 2, "expect 2 but got %d %v", reply, err)
+
+	var resp Orpc.ReflectionResponse
+	_ = client.Call(ctx, Orpc.ReflectionListMethod, Orpc.ReflectionRequest{Service: "Crash"}, &resp)
+	for _, m := range resp.Services[0].Methods {
+		if m.Name == "Index" {
+			_assert(m.NumCalls == 1 && m.NumPanics == 1, "unexpected counters %+v", m)
+		}
+	}
+
+	var stack string
+	server.SetPanicHandler(func(serviceMethod string, recovered interface{}, s []byte) error {
+		stack = string(s)
+		return errors.New("custom: " + serviceMethod)
+	})
+	err = client.Call(ctx, "Crash.Index", []int{}, &reply)
+	_assert(err != nil && err.Error() == "custom: Crash.Index", "expect custom error but got %v", err)
+	_assert(strings.Contains(stack, "Crash.Index"), "stack should contain the panicking method:\n%s", stack)
+
+	server.SetPanicHandler(func(string, interface{}, []byte) error { return nil })
+	err = client.Call(ctx, "Crash.Index", []int{}, &reply)
+	_assert(Orpc.IsInternalError(err), "nil from handler should fall back to the internal error, got %v", err)
+}
@@ -0,0 +1,56 @@
+package t_test
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/R-Goys/Orpc/server"
+)
+
+type Node struct {
+	Value    int `json:"value"`
+	Children []*Node
+	Labels   map[string]string
+}
+
+type Tree int
+
+func (Tree) Size(root Node, reply *int) error {
+	*reply = 1 + len(root.Children)
+	return nil
+}
+
+func Test_Reflection(t *testing.T) {
+	server := Orpc.NewServer()
+	_ = server.Register(new(Tree))
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	go server.Accept(l)
+	client, err := Orpc.XDial("tcp@" + l.Addr().String())
+	_assert(err == nil, "dial error %v", err)
+	defer func() { _ = client.Close() }()
+
+	var resp Orpc.ReflectionResponse
+	err = client.Call(context.Background(), Orpc.ReflectionListMethod, Orpc.ReflectionRequest{}, &resp)
+	_assert(err == nil, "list error %v", err)
+	var names []string
+	for _, s := range resp.Services {
+		names = append(names, s.Name)
+	}
+	_assert(len(names) == 3 && names[0] == "Health" && names[1] == "Reflection" && names[2] == "Tree", "unexpected services %v", names)
+
+	err = client.Call(context.Background(), Orpc.ReflectionListMethod, Orpc.ReflectionRequest{Service: "Tree"}, &resp)
+	_assert(err == nil && len(resp.Services) == 1, "describe error %v", err)
+	method := resp.Services[0].Methods[0]
+	_assert(method.Name == "Size" && method.ReplyType.Kind == "ptr" && method.ReplyType.Elem.Kind == "int", "unexpected method %+v", method)
+	arg := method.ArgType
+	_assert(arg.Kind == "struct" && arg.Name == "t_test.Node" && len(arg.Fields) == 3, "unexpected arg type %+v", arg)
+	_assert(arg.Fields[0].Name == "Value" && arg.Fields[0].Tag == `json:"value"`, "unexpected field %+v", arg.Fields[0])
+	children := arg.Fields[1].Type
+	_assert(children.Kind == "slice" && children.Elem.Kind == "ptr" && children.Elem.Elem.Ref, "recursive type should be a reference: %+v", children.Elem.Elem)
+	labels := arg.Fields[2].Type
+	_assert(labels.Kind == "map" && labels.Key.Kind == "string" && labels.Elem.Kind == "string", "unexpected map type %+v", labels)
+
+	err = client.Call(context.Background(), Orpc.ReflectionListMethod, Orpc.ReflectionRequest{Service: "Bar"}, &resp)
+	_assert(err != nil, "expect error for unknown service")
+}
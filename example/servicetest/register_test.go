@@ -0,0 +1,80 @@
+package t_test
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/R-Goys/Orpc/server"
+)
+
+type Mixed int
+
+type reply int
+
+func (Mixed) Ok(args int, reply *int) error           { return nil }
+func (Mixed) Arity(args int) error                    { return nil }
+func (Mixed) NoError(args int, reply *int) int        { return 0 }
+func (Mixed) Unexported(args reply, reply *int) error { return nil }
+func (Mixed) NotPointer(args int, reply int) error    { return nil }
+
+type Empty int
+
+func (Empty) Arity() error { return nil }
+
+type lower int
+
+func (lower) Ok(args int, reply *int) error { return nil }
+
+func Test_RegisterErrors(t *testing.T) {
+	server := Orpc.NewServer()
+	_assert(server.Register(new(Mixed)) == nil, "non-strict register should skip invalid methods")
+	svcs := server.Services()
+	_assert(len(svcs) == 1 && svcs[0] == "Mixed", "unexpected services %v", svcs)
+
+	var regErr *Orpc.RegistrationError
+	err := server.Register(new(lower))
+	_assert(errors.As(err, &regErr), "expect RegistrationError for unexported name but got %v", err)
+	err = server.Register(nil)
+	_assert(errors.As(err, &regErr), "expect RegistrationError for nil receiver but got %v", err)
+
+	err = server.Register(new(Empty))
+	_assert(errors.As(err, &regErr), "expect RegistrationError for zero-method service but got %v", err)
+	_assert(regErr.Service == "Empty" && len(regErr.Skipped) == 1 && regErr.Skipped[0].Name == "Arity", "unexpected error %+v", regErr)
+
+	strict := Orpc.NewServer()
+	strict.EnableStrictRegister()
+	err = strict.Register(new(Mixed))
+	_assert(errors.As(err, &regErr), "expect RegistrationError in strict mode but got %v", err)
+	reasons := make(map[string]string)
+	for _, m := range regErr.Skipped {
+		reasons[m.Name] = m.Reason
+	}
+	expect := map[string]string{
+		"Arity":      "expect 2 arguments and 1 return value but got 1 and 1",
+		"NoError":    "return type must be error but got int",
+		"Unexported": "argument type t_test.reply is not exported",
+		"NotPointer": "reply type int is not a pointer",
+	}
+	_assert(len(reasons) == len(expect), "unexpected skipped methods %v", reasons)
+	for name, reason := range expect {
+		_assert(reasons[name] == reason, "%s: expect %q but got %q", name, reason, reasons[name])
+	}
+	_assert(len(strict.Services()) == 0, "strict register should not add the service")
+	_assert(strict.RegisterName("Math", new(Mixed)) != nil, "RegisterName should be strict too")
+}
+
+func Test_RegisterLog(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	server := Orpc.NewServer()
+	_assert(buf.Len() == 0, "built-in services should not be logged: %q", buf.String())
+	_ = server.Register(new(Mixed))
+	out := buf.String()
+	_assert(strings.Contains(out, "register Method: Ok int"), "expect registered method to be logged: %q", out)
+	_assert(strings.Contains(out, "skip Method: Mixed.NotPointer"), "expect skipped method to be logged: %q", out)
+}
@@ -30,12 +30,13 @@ func _assert(condition bool, msg string, v ...interface{}) {
 
 func Test_Service(t *testing.T) {
 	var foo Foo
-	s := Orpc.NewService(&foo)
+	s, err := Orpc.NewService(&foo)
+	_assert(err == nil, "NewService error %v", err)
 	_assert(len(s.Method) == 1, "method should have 1 parameter but got ", len(s.Method))
 	mType := s.Method["Sum"]
 	argv := mType.NewArgv()
 	replyv := mType.NewReplyv()
 	argv.Set(reflect.ValueOf(Args{num1: 2, num2: 2}))
-	err := s.Call(mType, argv, replyv)
+	err = s.Call(mType, argv, replyv)
 	_assert(err == nil && *replyv.Interface().(*int) == 4 && mType.NumCalls() == 1, "failed to call Foo.Sum")
 }
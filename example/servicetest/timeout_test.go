@@ -0,0 +1,107 @@
+package t_test
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/R-Goys/Orpc/codec"
+	"github.com/R-Goys/Orpc/server"
+)
+
+func sleepServer(t *testing.T) (*Orpc.Server, string, chan error) {
+	server := Orpc.NewServer()
+	finished := make(chan error, 16)
+	err := Orpc.Handle(server, "Slow.Sleep", func(ctx context.Context, d time.Duration) (string, error) {
+		time.Sleep(d)
+		finished <- ctx.Err()
+		return "slept", nil
+	})
+	_assert(err == nil, "handle error %v", err)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Accept(l)
+	t.Cleanup(func() { _ = l.Close() })
+	return server, l.Addr().String(), finished
+}
+
+func Test_ZeroHandleTimeout(t *testing.T) {
+	_, addr, _ := sleepServer(t)
+	client, err := Orpc.Dial("tcp", addr, &Orpc.Option{CodecType: codec.GobType})
+	_assert(err == nil, "dial error %v", err)
+	defer func() { _ = client.Close() }()
+	var reply string
+	err = client.Call(context.Background(), "Slow.Sleep", 50*time.Millisecond, &reply)
+	_assert(err == nil && reply == "slept", "HandleTimeout 0 should mean no timeout, got %q %v", reply, err)
+}
+
+func Test_HandleTimeoutSingleResponse(t *testing.T) {
+	_, addr, finished := sleepServer(t)
+	conn, err := net.Dial("tcp", addr)
+	_assert(err == nil, "dial error %v", err)
+	defer func() { _ = conn.Close() }()
+	opt := Orpc.Option{MagicNumber: Orpc.MagicNumber, CodecType: codec.GobType, HandleTimeout: 50 * time.Millisecond}
+	_ = json.NewEncoder(conn).Encode(&opt)
+	cc := codec.NewGobCodec(conn)
+	_ = cc.Write(&codec.Header{ServiceMethod: "Slow.Sleep", Seq: 1}, 200*time.Millisecond)
+
+	var h codec.Header
+	_assert(cc.ReadHeader(&h) == nil && cc.ReadBody(nil) == nil, "read response")
+	_assert(h.Seq == 1 && strings.Contains(h.Error, "request handle timeout"), "expect timeout response but got %+v", h)
+	//方法通过 ctx 得知已经超时，返回后不会再发送第二个响应
+	select {
+	case err := <-finished:
+		_assert(err == context.DeadlineExceeded, "handler ctx should be expired but got %v", err)
+	case <-time.After(time.Second):
+		t.Fatal("handler did not finish")
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	err = cc.ReadHeader(&h)
+	ne, ok := err.(net.Error)
+	_assert(ok && ne.Timeout(), "expect no second response but got %+v %v", h, err)
+}
+
+func Test_HandleTimeoutNoLeak(t *testing.T) {
+	_, addr, finished := sleepServer(t)
+	client, err := Orpc.Dial("tcp", addr, &Orpc.Option{CodecType: codec.GobType, HandleTimeout: 20 * time.Millisecond})
+	_assert(err == nil, "dial error %v", err)
+	defer func() { _ = client.Close() }()
+	var reply string
+	_ = client.Call(context.Background(), "Slow.Sleep", time.Duration(0), &reply)
+	<-finished
+	time.Sleep(50 * time.Millisecond)
+	before := runtime.NumGoroutine()
+
+	const n = 10
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var reply string
+			err := client.Call(context.Background(), "Slow.Sleep", 100*time.Millisecond, &reply)
+			_assert(err != nil && strings.Contains(err.Error(), "request handle timeout"), "expect timeout but got %v", err)
+		}()
+	}
+	wg.Wait()
+	for i := 0; i < n; i++ {
+		<-finished
+	}
+	var after int
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if after = runtime.NumGoroutine(); after <= before {
+			break
+		}
+	}
+	_assert(after <= before, "goroutines leaked: %d before, %d after", before, after)
+	//同一个连接上之后的请求不受影响
+	err = client.Call(context.Background(), "Slow.Sleep", time.Duration(0), &reply)
+	_assert(err == nil && reply == "slept", "expect slept but got %q %v", reply, err)
+}
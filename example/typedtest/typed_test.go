@@ -0,0 +1,80 @@
+package test
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/R-Goys/Orpc/XClient"
+	Orpc "github.com/R-Goys/Orpc/server"
+)
+
+func _assert(condition bool, msg string, v ...interface{}) {
+	if !condition {
+		panic(fmt.Sprintf("assertion failed: "+msg, v...))
+	}
+}
+
+type Foo int
+
+type Args struct{ Num1, Num2 int }
+
+func (f Foo) Sum(args Args, reply *int) error {
+	*reply = args.Num1 + args.Num2
+	return nil
+}
+
+// SumArgs 与 Args 结构相同的另一个类型，gob 按字段名编码，可以互换
+type SumArgs struct{ Num1, Num2 int }
+
+type WrongArgs struct{ A, B int }
+
+func Test_Typed(t *testing.T) {
+	server := Orpc.NewServer()
+	_ = server.Register(new(Foo))
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	go server.Accept(l)
+	addr := "tcp@" + l.Addr().String()
+	ctx := context.Background()
+
+	client, err := Orpc.XDial(addr)
+	_assert(err == nil, "dial error %v", err)
+	defer func() { _ = client.Close() }()
+	sum, err := Orpc.CallTyped[Args, int](ctx, client, "Foo.Sum", Args{1, 2})
+	_assert(err == nil && sum == 3, "expect 3 but got %d %v", sum, err)
+
+	x := XClient.NewXClient(XClient.NewMultiServerDiscovery([]string{addr}), XClient.RandomSelect, nil)
+	defer func() { _ = x.Close() }()
+	sum, err = XClient.CallTyped[Args, int](ctx, x, "Foo.Sum", Args{2, 2})
+	_assert(err == nil && sum == 4, "expect 4 but got %d %v", sum, err)
+
+	good := &Orpc.Method[SumArgs, int]{Name: "Foo.Sum"}
+	sum, err = good.Call(ctx, client, SumArgs{3, 4})
+	_assert(err == nil && sum == 7, "expect 7 but got %d %v", sum, err)
+	sum, err = good.Call(ctx, x, SumArgs{1, 1})
+	_assert(err == nil && sum == 2, "expect 2 but got %d %v", sum, err)
+
+	//服务名中可以有点，与 FindService 一样在最后一个点处分开
+	_ = server.RegisterName("Primary.DB", new(Foo))
+	dotted := &Orpc.Method[Args, int]{Name: "Primary.DB.Sum"}
+	sum, err = dotted.Call(ctx, client, Args{5, 5})
+	_assert(err == nil && sum == 10, "expect 10 but got %d %v", sum, err)
+
+	wrongArgs := &Orpc.Method[WrongArgs, int]{Name: "Foo.Sum"}
+	_, err = wrongArgs.Call(ctx, client, WrongArgs{1, 2})
+	_assert(err != nil && strings.Contains(err.Error(), "args"), "expect args mismatch but got %v", err)
+	wrongReply := &Orpc.Method[Args, string]{Name: "Foo.Sum"}
+	_, err = wrongReply.Call(ctx, client, Args{1, 2})
+	_assert(err != nil && strings.Contains(err.Error(), "reply"), "expect reply mismatch but got %v", err)
+	missing := &Orpc.Method[Args, int]{Name: "Foo.Product"}
+	_, err = missing.Call(ctx, client, Args{1, 2})
+	_assert(err != nil, "expect method not found")
+
+	//服务端关闭 Reflection 时不做检查
+	server.DisableReflection()
+	unchecked := &Orpc.Method[Args, int]{Name: "Foo.Sum"}
+	sum, err = unchecked.Call(ctx, client, Args{5, 5})
+	_assert(err == nil && sum == 10, "expect 10 but got %d %v", sum, err)
+}
@@ -0,0 +1,204 @@
+package test
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/R-Goys/Orpc/Registry"
+	"github.com/R-Goys/Orpc/XClient"
+)
+
+func _assert(condition bool, msg string, v ...interface{}) {
+	if !condition {
+		panic(fmt.Sprintf("assertion failed: "+msg, v...))
+	}
+}
+
+func Test_Watch(t *testing.T) {
+	r := Registry.New(time.Second)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	d := XClient.NewOrpcRegisterDiscovery(ts.URL, time.Minute)
+	defer func() { _ = d.Close() }()
+	events := d.Watch()
+
+	r.PutServer("tcp@127.0.0.1:1")
+	select {
+	case e := <-events:
+		_assert(e.Type == XClient.ServerAdded && e.Addr == "tcp@127.0.0.1:1", "unexpected event %v", e)
+	case <-time.After(3 * time.Second):
+		t.Fatal("no added event")
+	}
+	servers, err := d.GetAll()
+	_assert(err == nil && len(servers) == 1, "expect 1 server but got %v", servers)
+
+	//不再发送心跳，等待注册中心将其剔除
+	select {
+	case e := <-events:
+		_assert(e.Type == XClient.ServerRemoved && e.Addr == "tcp@127.0.0.1:1", "unexpected event %v", e)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no removed event")
+	}
+	servers, _ = d.GetAll()
+	_assert(len(servers) == 0, "expect no server but got %v", servers)
+}
+
+func Test_ServiceScope(t *testing.T) {
+	r := Registry.New(0)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	r.PutService("Foo", "tcp@127.0.0.1:1", nil)
+	r.PutService("Bar", "tcp@127.0.0.1:2", nil)
+	d := XClient.NewOrpcServiceDiscovery(ts.URL, "Foo", time.Minute)
+	defer func() { _ = d.Close() }()
+	servers, err := d.GetAll()
+	_assert(err == nil && len(servers) == 1 && servers[0].Addr == "tcp@127.0.0.1:1", "unexpected servers %v %v", servers, err)
+
+	events := d.Watch()
+	r.PutService("Bar", "tcp@127.0.0.1:3", nil)
+	r.PutService("Foo", "tcp@127.0.0.1:4", nil)
+	select {
+	case e := <-events:
+		_assert(e.Type == XClient.ServerAdded && e.Addr == "tcp@127.0.0.1:4", "unexpected event %v", e)
+	case <-time.After(3 * time.Second):
+		t.Fatal("no added event")
+	}
+}
+
+func Test_Metadata(t *testing.T) {
+	r := Registry.New(0)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	r.PutService("Foo", "tcp@127.0.0.1:1", map[string]string{"zone": "a", "version": "v1", "weight": "10"})
+	r.PutService("Foo", "tcp@127.0.0.1:2", map[string]string{"zone": "b", "version": "v2", "tags": "canary,ssd"})
+	d := XClient.NewOrpcServiceDiscovery(ts.URL, "Foo", time.Minute)
+	defer func() { _ = d.Close() }()
+	servers, err := d.GetAll()
+	_assert(err == nil && len(servers) == 2, "unexpected servers %v %v", servers, err)
+	_assert(servers[0].Zone == "a" && servers[0].Weight == 10 && servers[1].HasTag("canary"), "unexpected metadata %v", servers)
+
+	_assert(len(XClient.PreferZone("b")(servers)) == 1, "expect 1 server in zone b")
+	_assert(len(XClient.PreferZone("c")(servers)) == 2, "expect fallback to all servers")
+	pinned := XClient.PinVersion("v1")(servers)
+	_assert(len(pinned) == 1 && pinned[0].Addr == "tcp@127.0.0.1:1", "unexpected pinned servers %v", pinned)
+	_assert(len(XClient.RequireTags("canary", "ssd")(servers)) == 1, "expect 1 tagged server")
+}
+
+func Test_XClientCloseDiscovery(t *testing.T) {
+	r := Registry.New(0)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	d := XClient.NewOrpcRegisterDiscovery(ts.URL, time.Minute)
+	events := d.Watch()
+	xc := XClient.NewXClient(d, XClient.RandomSelect, nil)
+	_assert(xc.Close() == nil, "close XClient")
+	//XClient 关闭时一并关闭 discovery，后台的长轮询随之退出
+	select {
+	case _, ok := <-events:
+		_assert(!ok, "expect watch channel to be closed")
+	case <-time.After(time.Second):
+		t.Fatal("discovery is not closed")
+	}
+	_assert(xc.Close() == nil, "close XClient twice")
+
+	//没有注册中心时不启动长轮询
+	d = XClient.NewOrpcServiceDiscovery("", "Foo", time.Minute)
+	defer func() { _ = d.Close() }()
+	_, err := d.GetAll()
+	_assert(err != nil, "expect error without registry")
+}
+
+func Test_RegistryDown(t *testing.T) {
+	r := Registry.New(0)
+	ts := httptest.NewServer(r)
+	r.PutService("Foo", "tcp@127.0.0.1:1", nil)
+	d := XClient.NewOrpcServiceDiscovery(ts.URL, "Foo", 50*time.Millisecond)
+	defer func() { _ = d.Close() }()
+	servers, err := d.GetAll()
+	_assert(err == nil && len(servers) == 1, "unexpected servers %v %v", servers, err)
+
+	//注册中心下线后继续使用缓存的服务列表，不在调用路径上访问注册中心
+	ts.CloseClientConnections()
+	ts.Close()
+	time.Sleep(100 * time.Millisecond)
+	start := time.Now()
+	s, err := d.Get(XClient.RandomSelect)
+	_assert(err == nil && s.Addr == "tcp@127.0.0.1:1", "expect cached server, got %v %v", s, err)
+	servers, err = d.GetAll()
+	_assert(err == nil && len(servers) == 1, "expect cached servers, got %v %v", servers, err)
+	_assert(time.Since(start) < 50*time.Millisecond, "Get should not wait for the registry")
+
+	//从来没有拿到过列表时返回错误
+	down := XClient.NewOrpcServiceDiscovery(ts.URL, "Foo", time.Minute)
+	defer func() { _ = down.Close() }()
+	_, err = down.GetAll()
+	_assert(err != nil, "expect error without any cached list")
+}
+
+// serveRegistry 在 addr 上启动一个新的内存注册中心，addr 为空时随机选择端口
+func serveRegistry(t *testing.T, addr string) (*Registry.ORegistry, *http.Server, string) {
+	if addr == "" {
+		addr = "127.0.0.1:0"
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Skip("address not reusable:", err)
+	}
+	r := Registry.New(0)
+	server := &http.Server{Handler: r}
+	go server.Serve(l)
+	return r, server, l.Addr().String()
+}
+
+func Test_RegistryRestart(t *testing.T) {
+	r, server, addr := serveRegistry(t, "")
+	r.PutService("Foo", "tcp@127.0.0.1:1", nil)
+	r.PutService("Foo", "tcp@127.0.0.1:2", nil)
+	d := XClient.NewOrpcServiceDiscovery("http://"+addr, "Foo", time.Minute)
+	defer func() { _ = d.Close() }()
+	servers, err := d.GetAll()
+	_assert(err == nil && len(servers) == 2, "unexpected servers %v %v", servers, err)
+
+	//注册中心重启后版本号重新增长到比客户端已知的更高，客户端需要重新拉取全量列表而不是应用增量
+	_ = server.Close()
+	r, server, _ = serveRegistry(t, addr)
+	defer func() { _ = server.Close() }()
+	for _, a := range []string{"tcp@127.0.0.1:3", "tcp@127.0.0.1:4", "tcp@127.0.0.1:5"} {
+		r.PutService("Foo", a, nil)
+	}
+	expect := []string{"tcp@127.0.0.1:3", "tcp@127.0.0.1:4", "tcp@127.0.0.1:5"}
+	deadline := time.Now().Add(5 * time.Second)
+	var addrs []string
+	for time.Now().Before(deadline) {
+		servers, _ = d.GetAll()
+		addrs = addrs[:0]
+		for _, s := range servers {
+			addrs = append(addrs, s.Addr)
+		}
+		if slices.Equal(addrs, expect) {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	_assert(slices.Equal(addrs, expect), "expect servers after restart %v but got %v", expect, addrs)
+
+	//旧协议同样通过 epoch 识别重启
+	req, _ := http.NewRequest("GET", "http://"+addr, nil)
+	req.Header.Set("X-Orpc-Service", "Foo")
+	req.Header.Set("X-Orpc-Watch", "1")
+	req.Header.Set("X-Orpc-Epoch", "stale")
+	resp, err := http.DefaultClient.Do(req)
+	_assert(err == nil, "watch error %v", err)
+	_ = resp.Body.Close()
+	_assert(resp.Header.Get("X-Orpc-Epoch") == r.Epoch(), "expect epoch header")
+	_assert(resp.Header.Get("X-Orpc-Servers") == "tcp@127.0.0.1:3,tcp@127.0.0.1:4,tcp@127.0.0.1:5", "expect full list, got %q", resp.Header.Get("X-Orpc-Servers"))
+}
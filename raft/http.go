@@ -0,0 +1,109 @@
+package raft
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+const (
+	votePath     = "/raft/vote"
+	appendPath   = "/raft/append"
+	snapshotPath = "/raft/snapshot"
+)
+
+// HTTPTransport 通过 HTTP 发送 JSON 请求，节点的 ID 为其 HTTP 地址，请求发送到 ID+"/raft/vote"、ID+"/raft/append" 与 ID+"/raft/snapshot"
+type HTTPTransport struct {
+	Client *http.Client
+}
+
+var _ Transport = (*HTTPTransport)(nil)
+
+func (t *HTTPTransport) RequestVote(ctx context.Context, peer string, args *RequestVoteArgs) (*RequestVoteReply, error) {
+	var reply RequestVoteReply
+	if err := t.post(ctx, peer+votePath, args, &reply); err != nil {
+		return nil, err
+	}
+	return &reply, nil
+}
+
+func (t *HTTPTransport) AppendEntries(ctx context.Context, peer string, args *AppendEntriesArgs) (*AppendEntriesReply, error) {
+	var reply AppendEntriesReply
+	if err := t.post(ctx, peer+appendPath, args, &reply); err != nil {
+		return nil, err
+	}
+	return &reply, nil
+}
+
+func (t *HTTPTransport) InstallSnapshot(ctx context.Context, peer string, args *InstallSnapshotArgs) (*InstallSnapshotReply, error) {
+	var reply InstallSnapshotReply
+	if err := t.post(ctx, peer+snapshotPath, args, &reply); err != nil {
+		return nil, err
+	}
+	return &reply, nil
+}
+
+func (t *HTTPTransport) post(ctx context.Context, url string, args, reply interface{}) error {
+	body, err := json.Marshal(args)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	client := t.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.New("raft: unexpected response " + resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(reply)
+}
+
+// ServeHTTP 处理 HTTPTransport 发来的请求，路径以 /raft/vote、/raft/append 或 /raft/snapshot 结尾
+func (n *Node) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	var reply interface{}
+	switch {
+	case strings.HasSuffix(req.URL.Path, votePath):
+		var args RequestVoteArgs
+		if err := json.NewDecoder(req.Body).Decode(&args); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		reply = n.HandleRequestVote(&args)
+	case strings.HasSuffix(req.URL.Path, appendPath):
+		var args AppendEntriesArgs
+		if err := json.NewDecoder(req.Body).Decode(&args); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		reply = n.HandleAppendEntries(&args)
+	case strings.HasSuffix(req.URL.Path, snapshotPath):
+		var args InstallSnapshotArgs
+		if err := json.NewDecoder(req.Body).Decode(&args); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		reply = n.HandleInstallSnapshot(&args)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(reply)
+}
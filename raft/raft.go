@@ -0,0 +1,735 @@
+package raft
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+type Role int
+
+const (
+	Follower Role = iota
+	Candidate
+	Leader
+)
+
+const (
+	DefaultElectionTimeout   = 300 * time.Millisecond
+	DefaultHeartbeatInterval = 50 * time.Millisecond
+	DefaultSnapshotThreshold = 1000
+	maxEntriesPerAppend      = 256
+)
+
+var (
+	ErrNotLeader       = errors.New("raft: not leader")
+	ErrLeadershipLost  = errors.New("raft: leadership lost before the entry was committed")
+	ErrStopped         = errors.New("raft: node stopped")
+	errNoDataToPropose = errors.New("raft: propose empty data")
+)
+
+// Entry 日志中的一条记录，Data 为 nil 的是新 leader 追加的空记录，不会交给 Apply
+type Entry struct {
+	Term  uint64 `json:"term"`
+	Index uint64 `json:"index"`
+	Data  []byte `json:"data,omitempty"`
+}
+
+type RequestVoteArgs struct {
+	Term         uint64 `json:"term"`
+	CandidateID  string `json:"candidateId"`
+	LastLogIndex uint64 `json:"lastLogIndex"`
+	LastLogTerm  uint64 `json:"lastLogTerm"`
+}
+
+type RequestVoteReply struct {
+	Term        uint64 `json:"term"`
+	VoteGranted bool   `json:"voteGranted"`
+}
+
+type AppendEntriesArgs struct {
+	Term         uint64  `json:"term"`
+	LeaderID     string  `json:"leaderId"`
+	PrevLogIndex uint64  `json:"prevLogIndex"`
+	PrevLogTerm  uint64  `json:"prevLogTerm"`
+	Entries      []Entry `json:"entries,omitempty"`
+	LeaderCommit uint64  `json:"leaderCommit"`
+}
+
+// AppendEntriesReply 失败时 LastIndex 提示 leader 下一次从哪里开始发送
+type AppendEntriesReply struct {
+	Term      uint64 `json:"term"`
+	Success   bool   `json:"success"`
+	LastIndex uint64 `json:"lastIndex"`
+}
+
+// InstallSnapshotArgs leader 需要发送的日志已经被压缩时，改为发送快照
+type InstallSnapshotArgs struct {
+	Term              uint64 `json:"term"`
+	LeaderID          string `json:"leaderId"`
+	LastIncludedIndex uint64 `json:"lastIncludedIndex"`
+	LastIncludedTerm  uint64 `json:"lastIncludedTerm"`
+	Data              []byte `json:"data"`
+}
+
+type InstallSnapshotReply struct {
+	Term uint64 `json:"term"`
+}
+
+// Transport 节点之间的通信方式，peer 为对方的 ID
+type Transport interface {
+	RequestVote(ctx context.Context, peer string, args *RequestVoteArgs) (*RequestVoteReply, error)
+	AppendEntries(ctx context.Context, peer string, args *AppendEntriesArgs) (*AppendEntriesReply, error)
+	InstallSnapshot(ctx context.Context, peer string, args *InstallSnapshotArgs) (*InstallSnapshotReply, error)
+}
+
+type Config struct {
+	ID                string   //本节点的 ID，同时也是其他节点访问它的地址
+	Peers             []string //集群全部节点的 ID，包含自己
+	Transport         Transport
+	Apply             func(data []byte) //按日志顺序应用已经提交的记录，在同一个 goroutine 中串行调用
+	ElectionTimeout   time.Duration     //实际的选举超时在 [ElectionTimeout, 2*ElectionTimeout) 中随机
+	HeartbeatInterval time.Duration
+
+	//Snapshot 返回状态机当前的快照，Restore 用快照替换状态机，两者都与 Apply 在同一个 goroutine 中调用。
+	//Snapshot 为 nil 时不压缩日志；否则已应用的日志超过 SnapshotThreshold 条（默认 1000）时做一次快照并丢弃之前的日志，
+	//落后于快照的节点会收到快照而不是日志
+	Snapshot          func() ([]byte, error)
+	Restore           func(data []byte)
+	SnapshotThreshold int
+}
+
+// Node 一个 Raft 节点。New 创建的节点只在内存中保存状态，重启后必须以新的 ID 重新加入集群；
+// Open 创建的节点把任期、投票与日志写入磁盘，可以以原来的 ID 重启
+type Node struct {
+	cfg Config
+
+	mu          sync.Mutex
+	role        Role
+	term        uint64
+	votedFor    string
+	leader      string
+	votes       int
+	log         []Entry //log[0] 是最近一次快照包含的最后一条记录，没有快照时为下标 0 的占位记录
+	commitIndex uint64
+	lastApplied uint64
+	nextIndex   map[string]uint64
+	matchIndex  map[string]uint64
+	inflight    map[string]bool //是否正在向某个节点发送 AppendEntries
+	deadline    time.Time       //选举超时的时间点
+	waiters     map[uint64]chan error
+	r           *rand.Rand
+
+	snapshot       []byte   //最近一次快照，用于发送给落后的节点
+	restorePending bool     //收到或者加载了快照，等待交给 Restore
+	storage        *storage //为 nil 时不持久化，见 Open
+
+	applyCh chan struct{}
+	stop    chan struct{}
+	wg      sync.WaitGroup
+	once    sync.Once
+}
+
+func New(cfg Config) *Node {
+	if cfg.ElectionTimeout == 0 {
+		cfg.ElectionTimeout = DefaultElectionTimeout
+	}
+	if cfg.HeartbeatInterval == 0 {
+		cfg.HeartbeatInterval = DefaultHeartbeatInterval
+	}
+	if cfg.SnapshotThreshold <= 0 {
+		cfg.SnapshotThreshold = DefaultSnapshotThreshold
+	}
+	n := &Node{
+		cfg:        cfg,
+		log:        []Entry{{}},
+		nextIndex:  make(map[string]uint64),
+		matchIndex: make(map[string]uint64),
+		inflight:   make(map[string]bool),
+		waiters:    make(map[uint64]chan error),
+		r:          rand.New(rand.NewSource(time.Now().UnixNano())),
+		applyCh:    make(chan struct{}, 1),
+		stop:       make(chan struct{}),
+	}
+	n.resetDeadline()
+	return n
+}
+
+// Start 启动选举计时和日志应用的 goroutine
+func (n *Node) Start() {
+	n.wg.Add(2)
+	go n.run()
+	go n.applier()
+	//Open 加载了快照时先把它交给 Restore
+	n.mu.Lock()
+	n.signalApply()
+	n.mu.Unlock()
+}
+
+// Stop 停止节点，等待中的 Propose 返回 ErrStopped
+func (n *Node) Stop() {
+	n.once.Do(func() {
+		close(n.stop)
+		n.wg.Wait()
+		n.mu.Lock()
+		for index, ch := range n.waiters {
+			ch <- ErrStopped
+			delete(n.waiters, index)
+		}
+		if n.storage != nil {
+			_ = n.storage.close()
+		}
+		n.mu.Unlock()
+	})
+}
+
+func (n *Node) ID() string {
+	return n.cfg.ID
+}
+
+// Leader 返回当前已知的 leader，未知时为空
+func (n *Node) Leader() string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.leader
+}
+
+func (n *Node) IsLeader() bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.role == Leader
+}
+
+func (n *Node) State() (role Role, term uint64) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.role, n.term
+}
+
+// Propose 由 leader 追加一条记录，阻塞到记录被多数节点复制并在本节点应用之后返回。
+// 不是 leader 时立即返回 ErrNotLeader
+func (n *Node) Propose(ctx context.Context, data []byte) error {
+	if data == nil {
+		return errNoDataToPropose
+	}
+	n.mu.Lock()
+	if n.role != Leader {
+		n.mu.Unlock()
+		return ErrNotLeader
+	}
+	index := n.lastIndex() + 1
+	e := Entry{Term: n.term, Index: index, Data: data}
+	if err := n.persistEntries(e); err != nil {
+		n.mu.Unlock()
+		return err
+	}
+	n.log = append(n.log, e)
+	ch := make(chan error, 1)
+	n.waiters[index] = ch
+	n.advanceCommit()
+	n.broadcast()
+	n.mu.Unlock()
+	select {
+	case err := <-ch:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-n.stop:
+		return ErrStopped
+	}
+}
+
+func (n *Node) run() {
+	defer n.wg.Done()
+	t := time.NewTicker(n.cfg.HeartbeatInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-n.stop:
+			return
+		case <-t.C:
+		}
+		n.mu.Lock()
+		if n.role == Leader {
+			n.broadcast()
+		} else if time.Now().After(n.deadline) {
+			n.startElection()
+		}
+		n.mu.Unlock()
+	}
+}
+
+func (n *Node) applier() {
+	defer n.wg.Done()
+	for {
+		select {
+		case <-n.stop:
+			return
+		case <-n.applyCh:
+		}
+		for {
+			n.mu.Lock()
+			if n.restorePending {
+				n.restorePending = false
+				data, index := n.snapshot, n.log[0].Index
+				n.mu.Unlock()
+				if n.cfg.Restore != nil {
+					n.cfg.Restore(data)
+				}
+				n.mu.Lock()
+				n.lastApplied = index
+				n.mu.Unlock()
+				continue
+			}
+			if n.lastApplied >= n.commitIndex {
+				n.mu.Unlock()
+				break
+			}
+			n.lastApplied++
+			e := n.entry(n.lastApplied)
+			ch := n.waiters[e.Index]
+			delete(n.waiters, e.Index)
+			n.mu.Unlock()
+			if e.Data != nil && n.cfg.Apply != nil {
+				n.cfg.Apply(e.Data)
+			}
+			if ch != nil {
+				ch <- nil
+			}
+		}
+		n.maybeSnapshot()
+	}
+}
+
+// maybeSnapshot 已应用的日志足够多时做一次快照，在 applier 中调用，此时状态机恰好应用到 lastApplied
+func (n *Node) maybeSnapshot() {
+	if n.cfg.Snapshot == nil {
+		return
+	}
+	n.mu.Lock()
+	index := n.lastApplied
+	need := !n.restorePending && index >= n.log[0].Index+uint64(n.cfg.SnapshotThreshold)
+	n.mu.Unlock()
+	if !need {
+		return
+	}
+	data, err := n.cfg.Snapshot()
+	if err != nil {
+		log.Printf("raft %s: snapshot error %v", n.cfg.ID, err)
+		return
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.compact(index, data)
+}
+
+// 以下方法调用方都需要持有 n.mu
+
+func (n *Node) lastIndex() uint64 {
+	return n.log[len(n.log)-1].Index
+}
+
+// entry 返回下标为 index 的记录，index 不能小于快照的下标
+func (n *Node) entry(index uint64) Entry {
+	return n.log[index-n.log[0].Index]
+}
+
+// persistState 把任期与投票写入磁盘，没有开启持久化时什么都不做
+func (n *Node) persistState() error {
+	if n.storage == nil {
+		return nil
+	}
+	return n.storage.saveState(hardState{Term: n.term, VotedFor: n.votedFor})
+}
+
+// persistEntries 把追加到日志末尾的记录写入磁盘
+func (n *Node) persistEntries(entries ...Entry) error {
+	if n.storage == nil || len(entries) == 0 {
+		return nil
+	}
+	return n.storage.append(entries)
+}
+
+// compact 丢弃 index 及之前的日志，以 data 作为它们的快照
+func (n *Node) compact(index uint64, data []byte) {
+	base := n.log[0].Index
+	if index <= base {
+		//期间已经收到了更新的快照
+		return
+	}
+	term := n.entry(index).Term
+	n.log = append([]Entry{{Term: term, Index: index}}, n.log[index-base+1:]...)
+	n.snapshot = data
+	if n.storage != nil {
+		if err := n.storage.saveSnapshot(snapshot{Index: index, Term: term, Data: data}, n.log[1:]); err != nil {
+			log.Printf("raft %s: save snapshot error %v", n.cfg.ID, err)
+		}
+	}
+}
+
+func (n *Node) resetDeadline() {
+	timeout := n.cfg.ElectionTimeout + time.Duration(n.r.Int63n(int64(n.cfg.ElectionTimeout)))
+	n.deadline = time.Now().Add(timeout)
+}
+
+func (n *Node) signalApply() {
+	select {
+	case n.applyCh <- struct{}{}:
+	default:
+	}
+}
+
+func (n *Node) becomeFollower(term uint64, leader string) {
+	if term > n.term {
+		n.term = term
+		n.votedFor = ""
+		if err := n.persistState(); err != nil {
+			log.Printf("raft %s: persist state error %v", n.cfg.ID, err)
+		}
+	}
+	if n.role != Follower {
+		log.Printf("raft %s: become follower at term %d", n.cfg.ID, n.term)
+	}
+	n.role = Follower
+	n.leader = leader
+	n.resetDeadline()
+}
+
+func (n *Node) startElection() {
+	n.role = Candidate
+	n.term++
+	n.votedFor = n.cfg.ID
+	n.leader = ""
+	n.votes = 1
+	n.resetDeadline()
+	if err := n.persistState(); err != nil {
+		//没有记下给自己的投票就不能发起选举，等待下一次超时
+		log.Printf("raft %s: persist state error %v", n.cfg.ID, err)
+		return
+	}
+	if n.votes*2 > len(n.cfg.Peers) {
+		n.becomeLeader()
+		return
+	}
+	args := &RequestVoteArgs{
+		Term:         n.term,
+		CandidateID:  n.cfg.ID,
+		LastLogIndex: n.lastIndex(),
+		LastLogTerm:  n.log[len(n.log)-1].Term,
+	}
+	for _, peer := range n.cfg.Peers {
+		if peer != n.cfg.ID {
+			go n.requestVote(peer, args)
+		}
+	}
+}
+
+func (n *Node) requestVote(peer string, args *RequestVoteArgs) {
+	ctx, cancel := context.WithTimeout(context.Background(), n.cfg.ElectionTimeout)
+	defer cancel()
+	reply, err := n.cfg.Transport.RequestVote(ctx, peer, args)
+	if err != nil {
+		return
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if reply.Term > n.term {
+		n.becomeFollower(reply.Term, "")
+		return
+	}
+	if n.role != Candidate || n.term != args.Term || !reply.VoteGranted {
+		return
+	}
+	if n.votes++; n.votes*2 > len(n.cfg.Peers) {
+		n.becomeLeader()
+	}
+}
+
+func (n *Node) becomeLeader() {
+	log.Printf("raft %s: become leader at term %d", n.cfg.ID, n.term)
+	n.role = Leader
+	n.leader = n.cfg.ID
+	for _, peer := range n.cfg.Peers {
+		n.nextIndex[peer] = n.lastIndex() + 1
+		n.matchIndex[peer] = 0
+	}
+	//追加一条本任期的空记录，之前任期的记录会随它一起被提交
+	e := Entry{Term: n.term, Index: n.lastIndex() + 1}
+	if err := n.persistEntries(e); err != nil {
+		log.Printf("raft %s: persist log error %v", n.cfg.ID, err)
+		n.becomeFollower(n.term, "")
+		return
+	}
+	n.log = append(n.log, e)
+	n.advanceCommit()
+	n.broadcast()
+}
+
+func (n *Node) broadcast() {
+	for _, peer := range n.cfg.Peers {
+		if peer != n.cfg.ID && !n.inflight[peer] {
+			n.inflight[peer] = true
+			go n.replicate(peer)
+		}
+	}
+}
+
+func (n *Node) replicate(peer string) {
+	n.mu.Lock()
+	if n.role != Leader {
+		n.inflight[peer] = false
+		n.mu.Unlock()
+		return
+	}
+	next := n.nextIndex[peer]
+	if next < 1 {
+		next = 1
+	}
+	if next <= n.log[0].Index {
+		//需要的日志已经被压缩
+		args := &InstallSnapshotArgs{
+			Term:              n.term,
+			LeaderID:          n.cfg.ID,
+			LastIncludedIndex: n.log[0].Index,
+			LastIncludedTerm:  n.log[0].Term,
+			Data:              n.snapshot,
+		}
+		n.mu.Unlock()
+		n.sendSnapshot(peer, args)
+		return
+	}
+	end := n.lastIndex() + 1
+	if end-next > maxEntriesPerAppend {
+		end = next + maxEntriesPerAppend
+	}
+	args := &AppendEntriesArgs{
+		Term:         n.term,
+		LeaderID:     n.cfg.ID,
+		PrevLogIndex: next - 1,
+		PrevLogTerm:  n.entry(next - 1).Term,
+		Entries:      append([]Entry(nil), n.log[next-n.log[0].Index:end-n.log[0].Index]...),
+		LeaderCommit: n.commitIndex,
+	}
+	n.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), n.cfg.ElectionTimeout)
+	reply, err := n.cfg.Transport.AppendEntries(ctx, peer, args)
+	cancel()
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.inflight[peer] = false
+	if err != nil {
+		return
+	}
+	if reply.Term > n.term {
+		n.becomeFollower(reply.Term, "")
+		return
+	}
+	if n.role != Leader || n.term != args.Term {
+		return
+	}
+	if reply.Success {
+		match := args.PrevLogIndex + uint64(len(args.Entries))
+		if match > n.matchIndex[peer] {
+			n.matchIndex[peer] = match
+		}
+		n.nextIndex[peer] = n.matchIndex[peer] + 1
+		n.advanceCommit()
+		if n.nextIndex[peer] <= n.lastIndex() {
+			n.inflight[peer] = true
+			go n.replicate(peer)
+		}
+		return
+	}
+	next = args.PrevLogIndex
+	if reply.LastIndex+1 < next {
+		next = reply.LastIndex + 1
+	}
+	if next < 1 {
+		next = 1
+	}
+	n.nextIndex[peer] = next
+	n.inflight[peer] = true
+	go n.replicate(peer)
+}
+
+func (n *Node) sendSnapshot(peer string, args *InstallSnapshotArgs) {
+	ctx, cancel := context.WithTimeout(context.Background(), n.cfg.ElectionTimeout)
+	reply, err := n.cfg.Transport.InstallSnapshot(ctx, peer, args)
+	cancel()
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.inflight[peer] = false
+	if err != nil {
+		return
+	}
+	if reply.Term > n.term {
+		n.becomeFollower(reply.Term, "")
+		return
+	}
+	if n.role != Leader || n.term != args.Term {
+		return
+	}
+	if args.LastIncludedIndex > n.matchIndex[peer] {
+		n.matchIndex[peer] = args.LastIncludedIndex
+	}
+	n.nextIndex[peer] = n.matchIndex[peer] + 1
+	n.advanceCommit()
+	if n.nextIndex[peer] <= n.lastIndex() {
+		n.inflight[peer] = true
+		go n.replicate(peer)
+	}
+}
+
+// advanceCommit 找到被多数节点复制的、属于当前任期的最大下标并提交
+func (n *Node) advanceCommit() {
+	for index := n.lastIndex(); index > n.commitIndex; index-- {
+		if n.entry(index).Term != n.term {
+			return
+		}
+		count := 1
+		for _, peer := range n.cfg.Peers {
+			if peer != n.cfg.ID && n.matchIndex[peer] >= index {
+				count++
+			}
+		}
+		if count*2 > len(n.cfg.Peers) {
+			n.commitIndex = index
+			n.signalApply()
+			return
+		}
+	}
+}
+
+// HandleRequestVote 处理其他节点的投票请求
+func (n *Node) HandleRequestVote(args *RequestVoteArgs) *RequestVoteReply {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if args.Term < n.term {
+		return &RequestVoteReply{Term: n.term}
+	}
+	if args.Term > n.term {
+		n.becomeFollower(args.Term, "")
+	}
+	lastTerm := n.log[len(n.log)-1].Term
+	upToDate := args.LastLogTerm > lastTerm || (args.LastLogTerm == lastTerm && args.LastLogIndex >= n.lastIndex())
+	if (n.votedFor == "" || n.votedFor == args.CandidateID) && upToDate {
+		n.votedFor = args.CandidateID
+		if err := n.persistState(); err != nil {
+			log.Printf("raft %s: persist state error %v", n.cfg.ID, err)
+			n.votedFor = ""
+			return &RequestVoteReply{Term: n.term}
+		}
+		n.resetDeadline()
+		return &RequestVoteReply{Term: n.term, VoteGranted: true}
+	}
+	return &RequestVoteReply{Term: n.term}
+}
+
+// HandleAppendEntries 处理 leader 的日志复制和心跳
+func (n *Node) HandleAppendEntries(args *AppendEntriesArgs) *AppendEntriesReply {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if args.Term < n.term {
+		return &AppendEntriesReply{Term: n.term, LastIndex: n.lastIndex()}
+	}
+	n.becomeFollower(args.Term, args.LeaderID)
+	if args.PrevLogIndex > n.lastIndex() {
+		return &AppendEntriesReply{Term: n.term, LastIndex: n.lastIndex()}
+	}
+	entries := args.Entries
+	if base := n.log[0].Index; args.PrevLogIndex < base {
+		//快照中的记录都已经提交，跳过它们
+		entries = entries[min(base-args.PrevLogIndex, uint64(len(entries))):]
+	} else if n.entry(args.PrevLogIndex).Term != args.PrevLogTerm {
+		n.truncate(args.PrevLogIndex)
+		return &AppendEntriesReply{Term: n.term, LastIndex: n.lastIndex()}
+	}
+	var appended []Entry
+	for _, e := range entries {
+		if e.Index <= n.lastIndex() {
+			if n.entry(e.Index).Term == e.Term {
+				continue
+			}
+			n.truncate(e.Index)
+		}
+		n.log = append(n.log, e)
+		appended = append(appended, e)
+	}
+	if err := n.persistEntries(appended...); err != nil {
+		//没有落盘的记录不能算作已经复制
+		log.Printf("raft %s: persist log error %v", n.cfg.ID, err)
+		n.log = n.log[:len(n.log)-len(appended)]
+		return &AppendEntriesReply{Term: n.term, LastIndex: n.lastIndex()}
+	}
+	last := args.PrevLogIndex + uint64(len(args.Entries))
+	if args.LeaderCommit > n.commitIndex {
+		n.commitIndex = args.LeaderCommit
+		if last < n.commitIndex {
+			n.commitIndex = last
+		}
+		n.signalApply()
+	}
+	return &AppendEntriesReply{Term: n.term, Success: true, LastIndex: n.lastIndex()}
+}
+
+// truncate 删除下标 index 及之后的记录，等待这些记录的 Propose 会失败
+func (n *Node) truncate(index uint64) {
+	if index <= n.commitIndex {
+		//已经提交的记录不会与 leader 冲突
+		return
+	}
+	for i := index; i <= n.lastIndex(); i++ {
+		if ch, ok := n.waiters[i]; ok {
+			ch <- ErrLeadershipLost
+			delete(n.waiters, i)
+		}
+	}
+	n.log = n.log[:index-n.log[0].Index]
+	if n.storage != nil {
+		if err := n.storage.rewrite(n.log[1:]); err != nil {
+			log.Printf("raft %s: rewrite log error %v", n.cfg.ID, err)
+		}
+	}
+}
+
+// HandleInstallSnapshot 处理 leader 发来的快照，快照之后与它一致的日志被保留
+func (n *Node) HandleInstallSnapshot(args *InstallSnapshotArgs) *InstallSnapshotReply {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if args.Term < n.term {
+		return &InstallSnapshotReply{Term: n.term}
+	}
+	n.becomeFollower(args.Term, args.LeaderID)
+	if args.LastIncludedIndex <= n.commitIndex {
+		//快照中的记录已经在本地提交
+		return &InstallSnapshotReply{Term: n.term}
+	}
+	var rest []Entry
+	if args.LastIncludedIndex <= n.lastIndex() && n.entry(args.LastIncludedIndex).Term == args.LastIncludedTerm {
+		rest = n.log[args.LastIncludedIndex-n.log[0].Index+1:]
+	}
+	for index, ch := range n.waiters {
+		if index <= args.LastIncludedIndex || rest == nil {
+			ch <- ErrLeadershipLost
+			delete(n.waiters, index)
+		}
+	}
+	n.log = append([]Entry{{Term: args.LastIncludedTerm, Index: args.LastIncludedIndex}}, rest...)
+	n.snapshot = args.Data
+	n.commitIndex = args.LastIncludedIndex
+	n.restorePending = true
+	if n.storage != nil {
+		snap := snapshot{Index: args.LastIncludedIndex, Term: args.LastIncludedTerm, Data: args.Data}
+		if err := n.storage.saveSnapshot(snap, n.log[1:]); err != nil {
+			log.Printf("raft %s: save snapshot error %v", n.cfg.ID, err)
+		}
+	}
+	n.signalApply()
+	return &InstallSnapshotReply{Term: n.term}
+}
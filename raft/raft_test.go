@@ -0,0 +1,443 @@
+package raft
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func _assert(condition bool, msg string, v ...interface{}) {
+	if !condition {
+		panic(fmt.Sprintf("assertion failed: "+msg, v...))
+	}
+}
+
+// network 在内存中传递请求，断开的节点既收不到也发不出请求
+type network struct {
+	mu    sync.Mutex
+	nodes map[string]*Node
+	down  map[string]bool
+}
+
+func (nw *network) target(from, to string) (*Node, error) {
+	nw.mu.Lock()
+	defer nw.mu.Unlock()
+	if nw.down[from] || nw.down[to] || nw.nodes[to] == nil {
+		return nil, errors.New("unreachable")
+	}
+	return nw.nodes[to], nil
+}
+
+func (nw *network) setDown(id string, down bool) {
+	nw.mu.Lock()
+	defer nw.mu.Unlock()
+	nw.down[id] = down
+}
+
+type memTransport struct {
+	nw   *network
+	from string
+}
+
+func (t *memTransport) RequestVote(_ context.Context, peer string, args *RequestVoteArgs) (*RequestVoteReply, error) {
+	n, err := t.nw.target(t.from, peer)
+	if err != nil {
+		return nil, err
+	}
+	return n.HandleRequestVote(args), nil
+}
+
+func (t *memTransport) AppendEntries(_ context.Context, peer string, args *AppendEntriesArgs) (*AppendEntriesReply, error) {
+	n, err := t.nw.target(t.from, peer)
+	if err != nil {
+		return nil, err
+	}
+	return n.HandleAppendEntries(args), nil
+}
+
+func (t *memTransport) InstallSnapshot(_ context.Context, peer string, args *InstallSnapshotArgs) (*InstallSnapshotReply, error) {
+	n, err := t.nw.target(t.from, peer)
+	if err != nil {
+		return nil, err
+	}
+	return n.HandleInstallSnapshot(args), nil
+}
+
+// machine 把已应用的记录按顺序保存下来的状态机
+type machine struct {
+	mu      sync.Mutex
+	values  []string
+	restore int //Restore 被调用的次数
+}
+
+func (m *machine) apply(data []byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.values = append(m.values, string(data))
+}
+
+func (m *machine) snapshot() ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return json.Marshal(m.values)
+}
+
+func (m *machine) restoreFrom(data []byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.values = nil
+	_ = json.Unmarshal(data, &m.values)
+	m.restore++
+}
+
+func (m *machine) len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.values)
+}
+
+type cluster struct {
+	nw       *network
+	ids      []string
+	machines map[string]*machine
+}
+
+func newCluster(size, threshold int) *cluster {
+	c := &cluster{nw: &network{nodes: make(map[string]*Node), down: make(map[string]bool)}, machines: make(map[string]*machine)}
+	for i := 0; i < size; i++ {
+		c.ids = append(c.ids, "n"+strconv.Itoa(i))
+	}
+	for _, id := range c.ids {
+		c.start(id, threshold, "")
+	}
+	return c
+}
+
+// start 启动 id 对应的节点，dir 不为空时使用 Open
+func (c *cluster) start(id string, threshold int, dir string) *Node {
+	m := &machine{}
+	cfg := Config{
+		ID:                id,
+		Peers:             c.ids,
+		Transport:         &memTransport{nw: c.nw, from: id},
+		Apply:             m.apply,
+		ElectionTimeout:   100 * time.Millisecond,
+		HeartbeatInterval: 20 * time.Millisecond,
+		SnapshotThreshold: threshold,
+	}
+	if threshold > 0 {
+		cfg.Snapshot, cfg.Restore = m.snapshot, m.restoreFrom
+	}
+	n := New(cfg)
+	if dir != "" {
+		var err error
+		if n, err = Open(cfg, dir); err != nil {
+			panic(err)
+		}
+	}
+	c.nw.mu.Lock()
+	c.nw.nodes[id] = n
+	c.nw.mu.Unlock()
+	c.machines[id] = m
+	n.Start()
+	return n
+}
+
+func (c *cluster) node(id string) *Node {
+	c.nw.mu.Lock()
+	defer c.nw.mu.Unlock()
+	return c.nw.nodes[id]
+}
+
+func (c *cluster) stop() {
+	for _, id := range c.ids {
+		c.node(id).Stop()
+	}
+}
+
+func (nw *network) isDown(id string) bool {
+	nw.mu.Lock()
+	defer nw.mu.Unlock()
+	return nw.down[id]
+}
+
+// waitLeader 等待连通的节点中只有一个 leader，并且它们都认可它
+func (c *cluster) waitLeader() *Node {
+	deadline := time.Now().Add(3 * time.Second)
+	for time.Now().Before(deadline) {
+		var up, leaders []*Node
+		for _, id := range c.ids {
+			if n := c.node(id); !c.nw.isDown(id) {
+				up = append(up, n)
+				if n.IsLeader() {
+					leaders = append(leaders, n)
+				}
+			}
+		}
+		agreed := len(leaders) == 1
+		for _, n := range up {
+			agreed = agreed && n.Leader() == leaders[0].ID()
+		}
+		if agreed {
+			return leaders[0]
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return nil
+}
+
+// newNode 创建一个与其他节点不连通、没有启动的节点，用于直接调用它的方法
+func newNode(id string) *Node {
+	return New(Config{ID: id, Peers: []string{"n0", "n1", "n2"}, Transport: &memTransport{nw: &network{}, from: id}})
+}
+
+func waitFor(f func() bool) bool {
+	deadline := time.Now().Add(3 * time.Second)
+	for time.Now().Before(deadline) {
+		if f() {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return false
+}
+
+func Test_Election(t *testing.T) {
+	c := newCluster(3, 0)
+	defer c.stop()
+	leader := c.waitLeader()
+	_assert(leader != nil, "no leader elected")
+	_, term := leader.State()
+
+	//leader 断开后剩下的节点在更高的任期选出新的 leader
+	c.nw.setDown(leader.ID(), true)
+	newLeader := c.waitLeader()
+	_assert(newLeader != nil && newLeader != leader, "no new leader elected")
+	_, newTerm := newLeader.State()
+	_assert(newTerm > term, "expect a higher term, got %d after %d", newTerm, term)
+	//旧 leader 得不到多数节点的确认，无法提交
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	_assert(leader.Propose(ctx, []byte("x")) != nil, "old leader should not commit without a majority")
+
+	//旧 leader 恢复后看到更高的任期，成为 follower
+	c.nw.setDown(leader.ID(), false)
+	_assert(waitFor(func() bool { return !leader.IsLeader() && leader.Leader() == newLeader.ID() }), "old leader should step down")
+}
+
+func Test_Vote(t *testing.T) {
+	n := newNode("n0")
+	n.log = append(n.log, Entry{Term: 2, Index: 1})
+
+	//日志落后的候选人拿不到选票
+	reply := n.HandleRequestVote(&RequestVoteArgs{Term: 3, CandidateID: "n1", LastLogIndex: 5, LastLogTerm: 1})
+	_assert(!reply.VoteGranted && reply.Term == 3, "expect vote to be refused, got %+v", reply)
+	reply = n.HandleRequestVote(&RequestVoteArgs{Term: 3, CandidateID: "n1", LastLogIndex: 1, LastLogTerm: 2})
+	_assert(reply.VoteGranted, "expect vote to be granted")
+	//同一个任期只投一次
+	reply = n.HandleRequestVote(&RequestVoteArgs{Term: 3, CandidateID: "n2", LastLogIndex: 1, LastLogTerm: 2})
+	_assert(!reply.VoteGranted, "expect only one vote per term")
+	reply = n.HandleRequestVote(&RequestVoteArgs{Term: 2, CandidateID: "n2", LastLogIndex: 1, LastLogTerm: 2})
+	_assert(!reply.VoteGranted && reply.Term == 3, "expect stale term to be refused")
+}
+
+func Test_LogConflict(t *testing.T) {
+	n := newNode("n1")
+	ok := n.HandleAppendEntries(&AppendEntriesArgs{Term: 1, LeaderID: "n0", Entries: []Entry{
+		{Term: 1, Index: 1, Data: []byte("a")}, {Term: 1, Index: 2, Data: []byte("b")}, {Term: 1, Index: 3, Data: []byte("c")},
+	}, LeaderCommit: 1})
+	_assert(ok.Success && ok.LastIndex == 3 && n.commitIndex == 1, "unexpected reply %+v", ok)
+
+	//前一条记录的任期不一致时拒绝，并截断冲突的记录
+	reply := n.HandleAppendEntries(&AppendEntriesArgs{Term: 2, LeaderID: "n2", PrevLogIndex: 3, PrevLogTerm: 2})
+	_assert(!reply.Success && reply.LastIndex == 2, "expect conflict at index 3, got %+v", reply)
+	//leader 的日志更短时，LastIndex 提示从哪里重新发送
+	reply = n.HandleAppendEntries(&AppendEntriesArgs{Term: 2, LeaderID: "n2", PrevLogIndex: 5, PrevLogTerm: 2})
+	_assert(!reply.Success && reply.LastIndex == 2, "expect hint at index 2, got %+v", reply)
+
+	//新任期的记录覆盖冲突的记录，重复的记录被忽略
+	reply = n.HandleAppendEntries(&AppendEntriesArgs{Term: 2, LeaderID: "n2", PrevLogIndex: 1, PrevLogTerm: 1, Entries: []Entry{
+		{Term: 2, Index: 2, Data: []byte("x")},
+	}, LeaderCommit: 5})
+	_assert(reply.Success && reply.LastIndex == 2, "unexpected reply %+v", reply)
+	_assert(n.entry(2).Term == 2 && string(n.entry(2).Data) == "x", "expect conflicting entry to be replaced")
+	//follower 最多提交到本次收到的最后一条记录
+	_assert(n.commitIndex == 2, "expect commit index 2, got %d", n.commitIndex)
+	reply = n.HandleAppendEntries(&AppendEntriesArgs{Term: 2, LeaderID: "n2", PrevLogIndex: 0, PrevLogTerm: 0, Entries: []Entry{
+		{Term: 1, Index: 1, Data: []byte("a")},
+	}})
+	_assert(reply.Success && n.lastIndex() == 2, "duplicated entries should not truncate the log")
+
+	//已经提交的记录不会被截断
+	n.truncate(1)
+	_assert(n.lastIndex() == 2, "committed entries should be kept")
+	//旧任期的请求被拒绝
+	reply = n.HandleAppendEntries(&AppendEntriesArgs{Term: 1, LeaderID: "n0", PrevLogIndex: 2, PrevLogTerm: 2})
+	_assert(!reply.Success && reply.Term == 2, "expect stale leader to be refused")
+}
+
+func Test_TruncateFailsProposal(t *testing.T) {
+	n := newNode("n0")
+	n.term, n.role, n.leader = 1, Leader, "n0"
+	done := make(chan error, 1)
+	go func() { done <- n.Propose(context.Background(), []byte("a")) }()
+	_assert(waitFor(func() bool {
+		n.mu.Lock()
+		defer n.mu.Unlock()
+		return n.lastIndex() == 1
+	}), "entry not appended")
+	//新 leader 在同一个下标写入了别的记录
+	reply := n.HandleAppendEntries(&AppendEntriesArgs{Term: 2, LeaderID: "n1", Entries: []Entry{{Term: 2, Index: 1}}})
+	_assert(reply.Success, "unexpected reply %+v", reply)
+	select {
+	case err := <-done:
+		_assert(errors.Is(err, ErrLeadershipLost), "expect ErrLeadershipLost, got %v", err)
+	case <-time.After(time.Second):
+		t.Fatal("proposal not failed")
+	}
+}
+
+func Test_CommitRule(t *testing.T) {
+	n := newNode("n0")
+	n.term, n.role = 3, Leader
+	n.log = append(n.log, Entry{Term: 1, Index: 1}, Entry{Term: 2, Index: 2})
+
+	//之前任期的记录即使被多数节点复制也不能单独提交
+	n.matchIndex["n1"] = 2
+	n.advanceCommit()
+	_assert(n.commitIndex == 0, "expect entries of previous terms to stay uncommitted, got %d", n.commitIndex)
+
+	//本任期的记录被多数节点复制后提交，之前的记录随之提交
+	n.log = append(n.log, Entry{Term: 3, Index: 3})
+	n.advanceCommit()
+	_assert(n.commitIndex == 0, "expect no commit without a majority, got %d", n.commitIndex)
+	n.matchIndex["n2"] = 3
+	n.advanceCommit()
+	_assert(n.commitIndex == 3, "expect commit index 3, got %d", n.commitIndex)
+}
+
+func Test_Replicate(t *testing.T) {
+	c := newCluster(3, 0)
+	defer c.stop()
+	leader := c.waitLeader()
+	_assert(leader != nil, "no leader elected")
+	for i := 0; i < 10; i++ {
+		_assert(leader.Propose(context.Background(), []byte(strconv.Itoa(i))) == nil, "propose %d", i)
+	}
+	_assert(waitFor(func() bool {
+		for _, id := range c.ids {
+			if c.machines[id].len() != 10 {
+				return false
+			}
+		}
+		return true
+	}), "entries not applied on every node")
+	var follower *Node
+	for _, id := range c.ids {
+		if id != leader.ID() {
+			follower = c.node(id)
+		}
+	}
+	_assert(errors.Is(follower.Propose(context.Background(), []byte("x")), ErrNotLeader), "expect ErrNotLeader")
+}
+
+func Test_SnapshotCatchUp(t *testing.T) {
+	c := newCluster(3, 5)
+	defer c.stop()
+	leader := c.waitLeader()
+	_assert(leader != nil, "no leader elected")
+	var lagging string
+	for _, id := range c.ids {
+		if id != leader.ID() {
+			lagging = id
+		}
+	}
+	c.nw.setDown(lagging, true)
+	for i := 0; i < 20; i++ {
+		_assert(leader.Propose(context.Background(), []byte(strconv.Itoa(i))) == nil, "propose %d", i)
+	}
+	leader.mu.Lock()
+	base := leader.log[0].Index
+	leader.mu.Unlock()
+	_assert(base >= 5, "expect the leader to compact its log, snapshot index %d", base)
+
+	//落后的节点需要的日志已经被压缩，leader 改为发送快照
+	c.nw.setDown(lagging, false)
+	m := c.machines[lagging]
+	_assert(waitFor(func() bool { return m.len() == 20 }), "lagging node did not catch up, got %d", m.len())
+	m.mu.Lock()
+	_assert(m.restore > 0 && m.values[0] == "0" && m.values[19] == "19", "unexpected state %v", m.values)
+	m.mu.Unlock()
+}
+
+func Test_Persist(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{ID: "n0", Peers: []string{"n0", "n1", "n2"}, Transport: &memTransport{nw: &network{}}}
+	n, err := Open(cfg, dir)
+	_assert(err == nil, "open %v", err)
+	reply := n.HandleRequestVote(&RequestVoteArgs{Term: 5, CandidateID: "n1"})
+	_assert(reply.VoteGranted, "expect vote to be granted")
+	reply2 := n.HandleAppendEntries(&AppendEntriesArgs{Term: 5, LeaderID: "n1", Entries: []Entry{
+		{Term: 5, Index: 1, Data: []byte("a")}, {Term: 5, Index: 2, Data: []byte("b")},
+	}})
+	_assert(reply2.Success, "unexpected reply %+v", reply2)
+	n.Stop()
+
+	//重启后记得任期与投票，同一个任期不会再投给别人
+	n, err = Open(cfg, dir)
+	_assert(err == nil, "reopen %v", err)
+	_, term := n.State()
+	_assert(term == 5 && n.lastIndex() == 2 && string(n.entry(2).Data) == "b", "state not restored: term %d, log %v", term, n.log)
+	reply = n.HandleRequestVote(&RequestVoteArgs{Term: 5, CandidateID: "n2", LastLogIndex: 2, LastLogTerm: 5})
+	_assert(!reply.VoteGranted, "expect no second vote in the same term")
+
+	//截断同样被持久化
+	reply2 = n.HandleAppendEntries(&AppendEntriesArgs{Term: 6, LeaderID: "n2", PrevLogIndex: 1, PrevLogTerm: 5, Entries: []Entry{
+		{Term: 6, Index: 2, Data: []byte("c")},
+	}, LeaderCommit: 2})
+	_assert(reply2.Success, "unexpected reply %+v", reply2)
+	n.compact(1, []byte(`["a"]`))
+	n.Stop()
+
+	m := &machine{}
+	cfg.Apply, cfg.Restore = m.apply, m.restoreFrom
+	n, err = Open(cfg, dir)
+	_assert(err == nil, "reopen %v", err)
+	_assert(n.log[0].Index == 1 && n.lastIndex() == 2 && string(n.entry(2).Data) == "c", "unexpected log %v", n.log)
+	n.Start()
+	defer n.Stop()
+	//启动时先恢复快照
+	_assert(waitFor(func() bool { return m.len() == 1 }), "snapshot not restored")
+}
+
+func Test_PersistCluster(t *testing.T) {
+	c := &cluster{nw: &network{nodes: make(map[string]*Node), down: make(map[string]bool)}, machines: make(map[string]*machine)}
+	c.ids = []string{"n0", "n1", "n2"}
+	dirs := map[string]string{}
+	for _, id := range c.ids {
+		dirs[id] = t.TempDir()
+		c.start(id, 5, dirs[id])
+	}
+	defer c.stop()
+	leader := c.waitLeader()
+	_assert(leader != nil, "no leader elected")
+	for i := 0; i < 12; i++ {
+		_assert(leader.Propose(context.Background(), []byte(strconv.Itoa(i))) == nil, "propose %d", i)
+	}
+
+	//以原来的 ID 重启 leader，它从磁盘恢复快照与日志并重新加入集群
+	id := leader.ID()
+	leader.Stop()
+	c.start(id, 5, dirs[id])
+	m := c.machines[id]
+	_assert(waitFor(func() bool { return m.len() == 12 }), "restarted node lost state, got %d", m.len())
+	newLeader := c.waitLeader()
+	_assert(newLeader != nil, "no leader after restart")
+	_assert(newLeader.Propose(context.Background(), []byte("12")) == nil, "propose after restart")
+	_assert(waitFor(func() bool { return m.len() == 13 }), "restarted node does not follow, got %d", m.len())
+}
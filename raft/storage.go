@@ -0,0 +1,217 @@
+package raft
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+const (
+	stateFile    = "raft-state.json"
+	snapshotFile = "raft-snapshot.json"
+	logFile      = "raft-log.json"
+)
+
+// hardState 投票之前必须落盘的状态，重启后不能在同一个任期投给另一个节点
+type hardState struct {
+	Term     uint64 `json:"term"`
+	VotedFor string `json:"votedFor,omitempty"`
+}
+
+// snapshot 日志压缩后留下的状态机快照，Index 与 Term 为它包含的最后一条记录
+type snapshot struct {
+	Index uint64 `json:"index"`
+	Term  uint64 `json:"term"`
+	Data  []byte `json:"data"`
+}
+
+// storage Raft 节点的磁盘存储：任期与投票、最近一次快照，以及快照之后的日志。
+// 日志文件只追加，下标不大于已有记录的记录表示它之后的记录被截断
+type storage struct {
+	dir    string
+	log    *os.File
+	closed bool //节点停止后不再写入
+}
+
+// Open 创建一个把任期、投票与日志持久化到 dir 目录的节点，dir 中已有的数据会被加载，
+// 有快照时节点启动后先把它交给 Restore，再从 leader 同步之后的日志
+func Open(cfg Config, dir string) (*Node, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	n := New(cfg)
+	s := &storage{dir: dir}
+	if err := s.load(n); err != nil {
+		return nil, err
+	}
+	//重写日志文件，丢弃加载时被截断或者已经在快照中的记录
+	if err := s.rewrite(n.log[1:]); err != nil {
+		return nil, err
+	}
+	n.storage = s
+	return n, nil
+}
+
+// load 读取任期、快照与日志，调用时 n 还没有启动
+func (s *storage) load(n *Node) error {
+	var state hardState
+	if err := readJSON(filepath.Join(s.dir, stateFile), &state); err != nil {
+		return err
+	}
+	n.term, n.votedFor = state.Term, state.VotedFor
+	var snap snapshot
+	if err := readJSON(filepath.Join(s.dir, snapshotFile), &snap); err != nil {
+		return err
+	}
+	if snap.Index > 0 {
+		n.log = []Entry{{Term: snap.Term, Index: snap.Index}}
+		n.snapshot = snap.Data
+		n.commitIndex = snap.Index
+		n.restorePending = true
+	}
+	f, err := os.Open(filepath.Join(s.dir, logFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	dec := json.NewDecoder(bufio.NewReader(f))
+	for {
+		var e Entry
+		if err = dec.Decode(&e); err != nil {
+			if err != io.EOF {
+				//最后一条记录可能只写了一半，丢弃它之后的内容
+				log.Println("raft: truncated log", err)
+			}
+			return nil
+		}
+		if e.Index <= n.log[0].Index {
+			continue
+		}
+		if e.Index > n.lastIndex()+1 {
+			log.Println("raft: gap in log at index", e.Index)
+			return nil
+		}
+		n.log = append(n.log[:e.Index-n.log[0].Index], e)
+	}
+}
+
+func readJSON(name string, v interface{}) error {
+	data, err := os.ReadFile(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// writeFileAtomic 先写临时文件再改名，保证文件总是完整的
+func writeFileAtomic(name string, data []byte) error {
+	tmp := name + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(data); err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, name)
+}
+
+func (s *storage) saveState(state hardState) error {
+	if s.closed {
+		return ErrStopped
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(s.dir, stateFile), data)
+}
+
+// encodeEntries 每条记录编码为一行 JSON
+func encodeEntries(entries []Entry) ([]byte, error) {
+	var buf []byte
+	for _, e := range entries {
+		data, err := json.Marshal(e)
+		if err != nil {
+			return nil, err
+		}
+		buf = append(append(buf, data...), '\n')
+	}
+	return buf, nil
+}
+
+// append 追加日志并等待落盘
+func (s *storage) append(entries []Entry) error {
+	if s.closed {
+		return ErrStopped
+	}
+	buf, err := encodeEntries(entries)
+	if err != nil {
+		return err
+	}
+	if _, err = s.log.Write(buf); err != nil {
+		return err
+	}
+	return s.log.Sync()
+}
+
+// rewrite 用 entries 替换整个日志文件
+func (s *storage) rewrite(entries []Entry) error {
+	if s.closed {
+		return ErrStopped
+	}
+	buf, err := encodeEntries(entries)
+	if err != nil {
+		return err
+	}
+	name := filepath.Join(s.dir, logFile)
+	if err = writeFileAtomic(name, buf); err != nil {
+		return err
+	}
+	if s.log != nil {
+		_ = s.log.Close()
+	}
+	s.log, err = os.OpenFile(name, os.O_APPEND|os.O_WRONLY, 0o644)
+	return err
+}
+
+// saveSnapshot 写入快照，并把日志文件替换为快照之后的 entries
+func (s *storage) saveSnapshot(snap snapshot, entries []Entry) error {
+	if s.closed {
+		return ErrStopped
+	}
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+	if err = writeFileAtomic(filepath.Join(s.dir, snapshotFile), data); err != nil {
+		return err
+	}
+	return s.rewrite(entries)
+}
+
+func (s *storage) close() error {
+	s.closed = true
+	if s.log == nil {
+		return nil
+	}
+	err := s.log.Close()
+	s.log = nil
+	return err
+}
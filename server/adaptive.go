@@ -0,0 +1,135 @@
+package Orpc
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrOverloaded 自适应并发限制拒绝请求时返回给客户端的错误前缀，可以用 IsOverloaded 判断
+const ErrOverloaded = "rpc server: overloaded"
+
+// adaptiveMinQueueing 延迟比最小延迟多出的部分小于它时不认为在排队，避免很快的方法因为调度抖动而降低上限
+const adaptiveMinQueueing = time.Millisecond
+
+// AdaptiveLimit 自适应并发限制（AIMD）的参数，零值使用默认值。
+// 请求从被读取到方法返回的延迟不超过最近观察到的最小延迟的 Tolerance 倍时，认为没有排队，上限每处理约 limit 个请求加一；
+// 超过时认为请求在排队，上限乘以 Backoff，每个最小延迟内最多下降一次。超过上限的请求在执行前直接被拒绝
+type AdaptiveLimit struct {
+	InitialLimit int           //默认 20
+	MinLimit     int           //默认 1
+	MaxLimit     int           //默认 1000
+	Tolerance    float64       //默认 2
+	Backoff      float64       //默认 0.9
+	ProbeWindow  time.Duration //最小延迟每隔 ProbeWindow 重新统计，以适应负载变化，默认 30s
+}
+
+// AdaptiveStats 自适应并发限制当前的状态
+type AdaptiveStats struct {
+	Limit      int           //当前允许同时处理的请求数
+	InFlight   int           //正在处理的请求数
+	MinLatency time.Duration //当前统计窗口内观察到的最小延迟
+	Shed       uint64        //被拒绝的请求总数
+}
+
+// EnableAdaptiveLimit 开启自适应并发限制，再次调用会以新的参数重新开始统计。它与 SetLimits 的静态限制同时生效，先于静态限制检查
+func (server *Server) EnableAdaptiveLimit(l AdaptiveLimit) {
+	if l.MinLimit <= 0 {
+		l.MinLimit = 1
+	}
+	if l.MaxLimit <= 0 {
+		l.MaxLimit = 1000
+	}
+	if l.MaxLimit < l.MinLimit {
+		l.MaxLimit = l.MinLimit
+	}
+	if l.InitialLimit <= 0 {
+		l.InitialLimit = 20
+	}
+	l.InitialLimit = min(max(l.InitialLimit, l.MinLimit), l.MaxLimit)
+	if l.Tolerance <= 1 {
+		l.Tolerance = 2
+	}
+	if l.Backoff <= 0 || l.Backoff >= 1 {
+		l.Backoff = 0.9
+	}
+	if l.ProbeWindow <= 0 {
+		l.ProbeWindow = 30 * time.Second
+	}
+	server.adaptive.Store(&adaptiveLimiter{AdaptiveLimit: l, limit: float64(l.InitialLimit)})
+}
+
+// AdaptiveStats 返回自适应并发限制的状态，没有开启时返回零值
+func (server *Server) AdaptiveStats() AdaptiveStats {
+	a := server.adaptive.Load()
+	if a == nil {
+		return AdaptiveStats{}
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return AdaptiveStats{Limit: int(a.limit), InFlight: a.inFlight, MinLatency: a.minLatency, Shed: a.shed}
+}
+
+// IsOverloaded 判断 Call 返回的错误是否因为服务端过载被拒绝
+func IsOverloaded(err error) bool {
+	return err != nil && strings.HasPrefix(err.Error(), ErrOverloaded)
+}
+
+type adaptiveLimiter struct {
+	AdaptiveLimit
+
+	mu           sync.Mutex
+	limit        float64
+	inFlight     int
+	minLatency   time.Duration
+	windowStart  time.Time
+	lastDecrease time.Time
+	shed         uint64
+}
+
+// acquire 成功时返回释放额度的函数
+func (a *adaptiveLimiter) acquire() (func(), error) {
+	if a == nil {
+		return func() {}, nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.inFlight >= int(a.limit) {
+		a.shed++
+		return nil, errors.New(ErrOverloaded + ": too many in-flight requests, try again later")
+	}
+	a.inFlight++
+	return func() {
+		a.mu.Lock()
+		a.inFlight--
+		a.mu.Unlock()
+	}, nil
+}
+
+// observe 记录一个执行完的请求的延迟并调整上限，在释放额度之前调用。被拒绝的请求不会被记录
+func (a *adaptiveLimiter) observe(latency time.Duration) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	now := time.Now()
+	if a.minLatency == 0 || latency < a.minLatency || now.Sub(a.windowStart) > a.ProbeWindow {
+		if now.Sub(a.windowStart) > a.ProbeWindow {
+			a.windowStart = now
+		}
+		a.minLatency = latency
+	}
+	if float64(latency) > float64(a.minLatency)*a.Tolerance && latency-a.minLatency > adaptiveMinQueueing {
+		if now.Sub(a.lastDecrease) >= a.minLatency {
+			a.limit = max(float64(a.MinLimit), a.limit*a.Backoff)
+			a.lastDecrease = now
+		}
+		return
+	}
+	//只有上限被用到一半以上时才增加，避免空闲时上限无限增长
+	if float64(a.inFlight) >= a.limit/2 {
+		a.limit = min(float64(a.MaxLimit), a.limit+1/a.limit)
+	}
+}
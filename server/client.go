@@ -24,13 +24,15 @@ type Call struct {
 	Reply         interface{}
 	Error         error
 	Done          chan *Call
+	Priority      Priority //请求的优先级，Call 从 ctx 中取得，见 WithPriority
 }
 
 type Option struct {
 	MagicNumber    int
 	CodecType      codec.Type
 	ConnectTimeOut time.Duration
-	HandleTimeout  time.Duration
+	HandleTimeout  time.Duration     //服务端处理每个请求的时间上限，为 0 时不限制
+	Metadata       map[string]string `json:",omitempty"` //随连接发送给服务端的信息，例如按客户端限流时使用的 client-id
 }
 
 type clientResult struct {
@@ -64,6 +66,13 @@ type Client struct {
 
 var ErrShutdown = errors.New("connection is shut down")
 
+// Caller 发起一次调用，*Client 与 XClient 都满足它，orpc-gen 生成的客户端基于它工作
+type Caller interface {
+	Call(ctx context.Context, serviceMethod string, args, reply interface{}) error
+}
+
+var _ Caller = (*Client)(nil)
+
 func (c *Client) Close() error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -71,7 +80,7 @@ func (c *Client) Close() error {
 		return ErrShutdown
 	}
 	c.closing = true
-	return nil
+	return c.cc.Close()
 }
 
 func (c *Client) IsAvailable() bool {
@@ -146,12 +155,12 @@ func NewClient(conn net.Conn, opt *Option) (*Client, error) {
 	if f == nil {
 		err := fmt.Errorf("orpc client codec err: %s", opt.CodecType)
 		log.Println(err)
-		return nil, nil
+		return nil, err
 	}
 	if err := json.NewEncoder(conn).Encode(opt); err != nil {
 		log.Println("Orpc client encode error:", err)
 		_ = conn.Close()
-		return nil, nil
+		return nil, err
 	}
 	return newClientCodec(f(conn), opt), nil
 }
@@ -233,6 +242,7 @@ func (c *Client) Send(call *Call) {
 	c.header.Seq = seq
 	c.header.ServiceMethod = call.ServiceMethod
 	c.header.Error = ""
+	c.header.Priority = int(call.Priority)
 	//发送
 	if err = c.cc.Write(&c.header, call.Args); err != nil {
 		call = c.RemoveCall(seq)
@@ -244,6 +254,10 @@ func (c *Client) Send(call *Call) {
 }
 
 func (c *Client) Go(serviceMethod string, args, reply interface{}, done chan *Call) *Call {
+	return c.goPriority(serviceMethod, args, reply, done, PriorityNormal)
+}
+
+func (c *Client) goPriority(serviceMethod string, args, reply interface{}, done chan *Call, priority Priority) *Call {
 	if done == nil {
 		done = make(chan *Call, 10)
 	} else if cap(done) == 0 {
@@ -254,13 +268,14 @@ func (c *Client) Go(serviceMethod string, args, reply interface{}, done chan *Ca
 		Args:          args,
 		Reply:         reply,
 		Done:          done,
+		Priority:      priority,
 	}
 	c.Send(call)
 	return call
 }
 
 func (c *Client) Call(ctx context.Context, serviceMethod string, args, reply interface{}) error {
-	call := c.Go(serviceMethod, args, reply, make(chan *Call, 1))
+	call := c.goPriority(serviceMethod, args, reply, make(chan *Call, 1), PriorityFromContext(ctx))
 	select {
 	case <-ctx.Done():
 		return ctx.Err()
@@ -0,0 +1,82 @@
+package Orpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go/ast"
+	"reflect"
+	"strings"
+)
+
+var (
+	typeOfContext = reflect.TypeOf((*context.Context)(nil)).Elem()
+	typeOfError   = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// RegisterFunc 把函数注册为 serviceMethod，函数签名为 func(ctx context.Context, args Args, reply *Reply) error，
+// 参数类型的要求与 Register 相同。同一个服务名下可以注册多个函数，但不能与 Register 注册的服务重名。
+// ctx 在请求处理超时后被取消
+func (server *Server) RegisterFunc(serviceMethod string, fn interface{}) error {
+	dot := strings.LastIndex(serviceMethod, ".")
+	if dot < 0 {
+		return errors.New("rpc server: service/method ill-formed: " + serviceMethod)
+	}
+	serviceName, methodName := serviceMethod[:dot], serviceMethod[dot+1:]
+	if !ast.IsExported(serviceName) || !ast.IsExported(methodName) {
+		return fmt.Errorf("rpc server: %s is not a valid service method name", serviceMethod)
+	}
+	f := reflect.ValueOf(fn)
+	if !f.IsValid() || f.Kind() != reflect.Func || f.IsNil() {
+		return &RegistrationError{Service: serviceName, Reason: fmt.Sprintf("%s: expect a non-nil func but got %T", methodName, fn)}
+	}
+	t := f.Type()
+	if t.NumIn() != 3 || t.NumOut() != 1 || t.In(0) != typeOfContext || t.Out(0) != typeOfError {
+		return fmt.Errorf("rpc server: %s: expect func(context.Context, Args, *Reply) error but got %s", serviceMethod, t)
+	}
+	argType, replyType := t.In(1), t.In(2)
+	if !isExportedOrBuiltinType(argType) || !isExportedOrBuiltinType(replyType) {
+		return fmt.Errorf("rpc server: %s: argument types must be exported", serviceMethod)
+	}
+	if replyType.Kind() != reflect.Ptr {
+		return fmt.Errorf("rpc server: %s: reply must be a pointer but got %s", serviceMethod, replyType)
+	}
+
+	//函数服务的方法表在注册时整体替换，读取方不需要加锁
+	server.mu.Lock()
+	svc := &Service{Name: serviceName, Method: make(map[string]*MethodType), calls: new(callTracker)}
+	svci, existed := server.serviceMap.Load(serviceName)
+	if existed {
+		old := svci.(*Service)
+		if old.rcvr.IsValid() {
+			server.mu.Unlock()
+			return errors.New("Orpc service already defined " + serviceName)
+		}
+		if old.Method[methodName] != nil {
+			server.mu.Unlock()
+			return errors.New("Orpc method already defined " + serviceMethod)
+		}
+		for name, m := range old.Method {
+			svc.Method[name] = m
+		}
+		svc.calls = old.calls
+	}
+	svc.Method[methodName] = &MethodType{ArgType: argType, ReplyType: replyType, fn: f}
+	server.serviceMap.Store(serviceName, svc)
+	server.mu.Unlock()
+	if !existed {
+		server.servicesChanged()
+	}
+	return nil
+}
+
+// Handle 以泛型函数的形式注册 serviceMethod，例如
+//
+//	Orpc.Handle(server, "Math.Add", func(ctx context.Context, args Args) (int, error) { ... })
+func Handle[Req, Resp any](server *Server, serviceMethod string, fn func(ctx context.Context, req Req) (Resp, error)) error {
+	return server.RegisterFunc(serviceMethod, func(ctx context.Context, req Req, resp *Resp) error {
+		r, err := fn(ctx, req)
+		*resp = r
+		return err
+	})
+}
@@ -0,0 +1,170 @@
+package Orpc
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	healthServiceName = "Health"
+	// HealthCheckMethod 健康检查使用的方法名，注册中心通过它主动探测实例是否还能处理请求
+	HealthCheckMethod = "Health.Check"
+	HealthWatchMethod = "Health.Watch"
+
+	defaultHealthWatchTimeout = time.Second * 30
+)
+
+// ServingStatus 服务的健康状态
+type ServingStatus int
+
+const (
+	StatusUnknown ServingStatus = iota
+	StatusServing
+	StatusNotServing
+)
+
+func (s ServingStatus) String() string {
+	switch s {
+	case StatusServing:
+		return "SERVING"
+	case StatusNotServing:
+		return "NOT_SERVING"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// HealthCheckRequest Service 为空时询问整个 server 的状态
+type HealthCheckRequest struct {
+	Service string
+}
+
+type HealthCheckResponse struct {
+	Status ServingStatus
+}
+
+// HealthWatchRequest 阻塞直到 Service 的状态不再是 Status 或者超过 Timeout，
+// Timeout 为 0 时等待 30s，调用方的 HandleTimeout 需要比它更长
+type HealthWatchRequest struct {
+	Service string
+	Status  ServingStatus
+	Timeout time.Duration
+}
+
+// Health 每个 Server 自带的健康检查服务，以 Health 为服务名注册，可以通过 DisableHealth 关闭。
+// 已注册的服务与整个 server（服务名为空）默认为 SERVING，未注册的服务为 UNKNOWN，
+// 应用可以通过 SetServingStatus 修改，server 开始 Shutdown 后全部变为 NOT_SERVING
+type Health struct {
+	server *Server
+
+	mu       sync.Mutex
+	statuses map[string]ServingStatus
+	shutdown bool
+	changed  chan struct{} //状态变化时关闭，用于唤醒 Watch
+}
+
+func newHealth(server *Server) *Health {
+	return &Health{
+		server:   server,
+		statuses: make(map[string]ServingStatus),
+		changed:  make(chan struct{}),
+	}
+}
+
+// SetServingStatus 设置 service 的状态，service 为空时设置整个 server 的状态
+func (h *Health) SetServingStatus(service string, status ServingStatus) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.statuses[service] = status
+	h.notify()
+}
+
+// Status 返回 service 当前的状态
+func (h *Health) Status(service string) ServingStatus {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.status(service)
+}
+
+// status 调用方需要持有 h.mu
+func (h *Health) status(service string) ServingStatus {
+	if h.shutdown {
+		return StatusNotServing
+	}
+	if s, ok := h.statuses[service]; ok {
+		return s
+	}
+	if service == "" {
+		return StatusServing
+	}
+	if _, ok := h.server.serviceMap.Load(service); ok && !isBuiltinService(service) {
+		return StatusServing
+	}
+	return StatusUnknown
+}
+
+// notify 唤醒所有 Watch，调用方需要持有 h.mu
+func (h *Health) notify() {
+	close(h.changed)
+	h.changed = make(chan struct{})
+}
+
+func (h *Health) startShutdown() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.shutdown = true
+	h.notify()
+}
+
+func (h *Health) Check(req HealthCheckRequest, resp *HealthCheckResponse) error {
+	resp.Status = h.Status(req.Service)
+	return nil
+}
+
+func (h *Health) Watch(req HealthWatchRequest, resp *HealthCheckResponse) error {
+	timeout := req.Timeout
+	if timeout <= 0 {
+		timeout = defaultHealthWatchTimeout
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		h.mu.Lock()
+		resp.Status = h.status(req.Service)
+		changed := h.changed
+		h.mu.Unlock()
+		if resp.Status != req.Status {
+			return nil
+		}
+		select {
+		case <-changed:
+		case <-timer.C:
+			return nil
+		}
+	}
+}
+
+// Health 返回 server 自带的健康检查服务
+func (server *Server) Health() *Health {
+	return server.health
+}
+
+// DisableHealth 不再对外提供 Health 服务，Health() 返回的对象仍然可以使用
+func (server *Server) DisableHealth() {
+	server.serviceMap.Delete(healthServiceName)
+}
+
+// CheckHealth 调用对端的 Health.Check，对端没有 Health 服务时只要能正常响应就视为 SERVING
+func (c *Client) CheckHealth(ctx context.Context, service string) (ServingStatus, error) {
+	var resp HealthCheckResponse
+	err := c.Call(ctx, HealthCheckMethod, HealthCheckRequest{Service: service}, &resp)
+	if err != nil {
+		if strings.HasPrefix(err.Error(), "rpc server: service not found") {
+			return StatusServing, nil
+		}
+		return StatusUnknown, err
+	}
+	return resp.Status, nil
+}
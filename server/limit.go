@@ -0,0 +1,256 @@
+package Orpc
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrResourceExhausted 请求超过并发上限被拒绝时返回给客户端的错误前缀，可以用 IsResourceExhausted 判断。
+// 被拒绝的请求没有执行，可以安全地重试
+const ErrResourceExhausted = "rpc server: resource exhausted"
+
+// Limits 服务端的并发限制，零值表示不限制
+type Limits struct {
+	MaxInFlight        int            //整个 server 同时处理的请求数上限
+	MaxInFlightPerConn int            //每个连接同时处理的请求数上限，只对之后建立的连接生效
+	Methods            map[string]int //每个 Service.Method 同时处理的请求数上限
+
+	//Workers 大于 0 时由固定数量的 worker 处理请求，而不是为每个请求启动一个 goroutine；
+	//所有 worker 都忙时请求在长度为 QueueSize 的队列中等待，优先级高的请求先执行，见 Priority。
+	//队列已满时，新的请求会顶替队列中优先级比它低的请求，被顶替的请求收到 ErrResourceExhausted
+	Workers   int
+	QueueSize int
+
+	//Backpressure 为 true 时，达到 MaxInFlight、MaxInFlightPerConn 或者队列已满时暂停读取该连接，直到有请求处理完，
+	//而不是返回 ErrResourceExhausted。Methods 的限制总是返回错误，避免一个方法阻塞整个连接
+	Backpressure bool
+}
+
+// SetLimits 替换服务端的并发限制，已经在处理的请求按原来的限制计数
+func (server *Server) SetLimits(l Limits) {
+	lim := newLimiter(l)
+	if old := server.limiter.Swap(lim); old != nil {
+		old.close()
+	}
+}
+
+// IsResourceExhausted 判断 Call 返回的错误是否因为服务端达到并发上限
+func IsResourceExhausted(err error) bool {
+	return err != nil && strings.HasPrefix(err.Error(), ErrResourceExhausted)
+}
+
+// admit 依次检查自适应并发限制与 lim 的静态限制，成功时设置 req.release
+func (server *Server) admit(req *request, lim *limiter, conn semaphore) error {
+	adaptive := server.adaptive.Load()
+	releaseAdaptive, err := adaptive.acquire()
+	if err != nil {
+		return err
+	}
+	release, err := lim.admit(req.header.ServiceMethod, conn)
+	if err != nil {
+		releaseAdaptive()
+		return err
+	}
+	req.adaptive, req.admitted = adaptive, time.Now()
+	req.release = func() {
+		release()
+		releaseAdaptive()
+	}
+	return nil
+}
+
+type limiter struct {
+	Limits
+	global  semaphore
+	methods map[string]semaphore
+	pool    *workerPool
+}
+
+func newLimiter(l Limits) *limiter {
+	lim := &limiter{Limits: l, global: newSemaphore(l.MaxInFlight), methods: make(map[string]semaphore)}
+	for method, n := range l.Methods {
+		lim.methods[method] = newSemaphore(n)
+	}
+	if l.Workers > 0 {
+		lim.pool = newWorkerPool(l.Workers, l.QueueSize)
+	}
+	return lim
+}
+
+// admit 依次占用 server、连接以及方法的并发额度，成功时返回释放它们的函数
+func (lim *limiter) admit(serviceMethod string, conn semaphore) (func(), error) {
+	if lim == nil {
+		return func() {}, nil
+	}
+	if !lim.global.acquire(lim.Backpressure) {
+		return nil, errors.New(ErrResourceExhausted + ": too many in-flight requests on the server")
+	}
+	if !conn.acquire(lim.Backpressure) {
+		lim.global.release()
+		return nil, errors.New(ErrResourceExhausted + ": too many in-flight requests on the connection")
+	}
+	method := lim.methods[serviceMethod]
+	if !method.acquire(false) {
+		conn.release()
+		lim.global.release()
+		return nil, errors.New(ErrResourceExhausted + ": too many in-flight requests for " + serviceMethod)
+	}
+	return func() {
+		method.release()
+		conn.release()
+		lim.global.release()
+	}, nil
+}
+
+// dispatch 在 worker 或者新的 goroutine 中执行 task，队列已满时返回 false。
+// 队列中优先级更低的请求为 priority 让出位置时，对它调用 shed
+func (lim *limiter) dispatch(priority int, task func(), shed func(error)) bool {
+	if lim == nil || lim.pool == nil {
+		go task()
+		return true
+	}
+	ok, evicted := lim.pool.submit(poolTask{priority: priority, run: task, shed: shed}, lim.Backpressure)
+	if evicted != nil {
+		//被顶替的请求属于另一个连接，在新的 goroutine 中写出响应，不阻塞当前连接的读取
+		go evicted.shed(errors.New(ErrResourceExhausted + ": shed for a higher priority request"))
+	}
+	return ok
+}
+
+func (lim *limiter) connSemaphore() semaphore {
+	if lim == nil {
+		return nil
+	}
+	return newSemaphore(lim.MaxInFlightPerConn)
+}
+
+func (lim *limiter) close() {
+	if lim != nil && lim.pool != nil {
+		lim.pool.close()
+	}
+}
+
+// semaphore 为 nil 时不限制
+type semaphore chan struct{}
+
+func newSemaphore(n int) semaphore {
+	if n <= 0 {
+		return nil
+	}
+	return make(semaphore, n)
+}
+
+func (s semaphore) acquire(block bool) bool {
+	if s == nil {
+		return true
+	}
+	if block {
+		s <- struct{}{}
+		return true
+	}
+	select {
+	case s <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s semaphore) release() {
+	if s != nil {
+		<-s
+	}
+}
+
+// workerPool 固定数量的 worker 执行队列中的任务，优先级高的先执行，相同优先级按先后顺序
+type workerPool struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	queue  []poolTask //按优先级从高到低排列
+	size   int        //队列长度，空闲的 worker 不占用队列
+	idle   int
+	closed bool
+}
+
+type poolTask struct {
+	priority int
+	run      func()
+	shed     func(error) //任务在执行之前被丢弃时调用
+}
+
+func newWorkerPool(workers, size int) *workerPool {
+	p := &workerPool{size: size}
+	p.cond = sync.NewCond(&p.mu)
+	for i := 0; i < workers; i++ {
+		go p.work()
+	}
+	return p
+}
+
+// submit 队列已满时，block 为 true 则等待空位；否则如果队列中最后一个任务的优先级低于 t，丢弃它并返回，
+// 由调用方在锁外调用它的 shed，没有可以丢弃的任务时返回 false
+func (p *workerPool) submit(t poolTask, block bool) (bool, *poolTask) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var evicted *poolTask
+	for !p.closed && len(p.queue) >= p.size+p.idle {
+		if last := len(p.queue) - 1; !block && last >= 0 && p.queue[last].priority < t.priority {
+			e := p.queue[last]
+			evicted = &e
+			p.queue[last] = poolTask{}
+			p.queue = p.queue[:last]
+			break
+		}
+		if !block {
+			return false, nil
+		}
+		p.cond.Wait()
+	}
+	if p.closed {
+		//已经被 SetLimits 替换或者 server 已经关闭，不再占用 worker
+		go t.run()
+		return true, evicted
+	}
+	//插入到相同优先级的最后
+	i := len(p.queue)
+	for i > 0 && p.queue[i-1].priority < t.priority {
+		i--
+	}
+	p.queue = slices.Insert(p.queue, i, t)
+	p.cond.Broadcast()
+	return true, evicted
+}
+
+func (p *workerPool) work() {
+	p.mu.Lock()
+	for {
+		for len(p.queue) == 0 && !p.closed {
+			p.idle++
+			p.cond.Wait()
+			p.idle--
+		}
+		if len(p.queue) == 0 {
+			p.mu.Unlock()
+			return
+		}
+		t := p.queue[0]
+		p.queue[0] = poolTask{}
+		p.queue = p.queue[1:]
+		//唤醒等待空位的 submit
+		p.cond.Broadcast()
+		p.mu.Unlock()
+		t.run()
+		p.mu.Lock()
+	}
+}
+
+// close 队列中剩余的任务执行完后 worker 退出
+func (p *workerPool) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	p.cond.Broadcast()
+}
@@ -0,0 +1,26 @@
+package Orpc
+
+import "context"
+
+// Priority 请求的优先级，随请求头发送。worker 池饱和时优先执行优先级高的请求，队列已满时先丢弃优先级最低的请求
+type Priority int
+
+const (
+	PriorityLow      Priority = -1
+	PriorityNormal   Priority = 0 //默认优先级，旧的客户端发送的请求也是这个优先级
+	PriorityHigh     Priority = 1
+	PriorityCritical Priority = 2
+)
+
+type priorityKey struct{}
+
+// WithPriority 返回带有优先级的 ctx，使用它调用 Client.Call 或 XClient.Call 的请求以 p 的优先级发送
+func WithPriority(ctx context.Context, p Priority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, p)
+}
+
+// PriorityFromContext 返回 ctx 中的优先级，没有设置时为 PriorityNormal
+func PriorityFromContext(ctx context.Context) Priority {
+	p, _ := ctx.Value(priorityKey{}).(Priority)
+	return p
+}
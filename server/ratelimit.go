@@ -0,0 +1,169 @@
+package Orpc
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"strings"
+	"time"
+)
+
+// ErrRateLimited 请求超过限流被拒绝时返回给客户端的错误前缀，可以用 IsRateLimited 判断
+const ErrRateLimited = "rpc server: rate limited"
+
+// maxIdleBuckets 按客户端限流时保留的令牌桶数量超过它后，清理已经回满的桶
+const maxIdleBuckets = 1024
+
+// RateLimit 令牌桶限流：每秒补充 Rate 个令牌，最多积累 Burst 个，每个请求消耗一个
+type RateLimit struct {
+	Rate      float64
+	Burst     int  //为 0 时取 Rate 向上取整，至少为 1
+	PerClient bool //为 true 时每个客户端使用单独的令牌桶，否则所有客户端共享
+}
+
+// ConnInfo 连接的信息，用于计算客户端 ID
+type ConnInfo struct {
+	RemoteAddr string            //远端地址，无法获取时为空
+	Metadata   map[string]string //客户端在 Option.Metadata 中携带的信息
+}
+
+// SetRateLimit 为 pattern 设置限流，pattern 为 Service.Method 或者 Service（服务下的所有方法共用令牌桶），
+// 同时匹配两者的请求需要两边都有令牌。Rate 为 0 时取消限流。请求在进入并发限制与执行方法之前检查限流，被并发限制拒绝的请求不消耗令牌
+func (server *Server) SetRateLimit(pattern string, l RateLimit) {
+	server.rateMu.Lock()
+	defer server.rateMu.Unlock()
+	if server.rateLimits == nil {
+		server.rateLimits = make(map[string]*rateRule)
+	}
+	if l.Rate <= 0 {
+		delete(server.rateLimits, pattern)
+		return
+	}
+	if l.Burst <= 0 {
+		l.Burst = int(math.Max(1, math.Ceil(l.Rate)))
+	}
+	server.rateLimits[pattern] = &rateRule{RateLimit: l, clients: make(map[string]*tokenBucket)}
+}
+
+// SetClientID 设置按客户端限流时如何识别客户端，默认使用 Metadata 中的 client-id，没有时使用远端地址的 host。
+// 只对之后建立的连接生效
+func (server *Server) SetClientID(f func(info ConnInfo) string) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	server.clientID = f
+}
+
+// IsRateLimited 判断 Call 返回的错误是否因为服务端限流
+func IsRateLimited(err error) bool {
+	return err != nil && strings.HasPrefix(err.Error(), ErrRateLimited)
+}
+
+// IsRetryable 判断 Call 返回的错误是否表示请求被服务端拒绝而没有执行，这类请求可以由重试策略换一个实例或者稍后重试
+func IsRetryable(err error) bool {
+	return IsRateLimited(err) || IsResourceExhausted(err) || IsOverloaded(err)
+}
+
+func defaultClientID(info ConnInfo) string {
+	if id := info.Metadata["client-id"]; id != "" {
+		return id
+	}
+	if host, _, err := net.SplitHostPort(info.RemoteAddr); err == nil {
+		return host
+	}
+	return info.RemoteAddr
+}
+
+func (server *Server) connClientID(info ConnInfo) string {
+	server.mu.Lock()
+	f := server.clientID
+	server.mu.Unlock()
+	if f == nil {
+		f = defaultClientID
+	}
+	return f(info)
+}
+
+// allow 检查 serviceMethod 以及所在服务的限流，client 为连接的客户端 ID。只有所有匹配的令牌桶都有令牌时才会取走令牌，
+// 返回的 refund 把取走的令牌还回去，请求随后被并发限制拒绝时调用，避免没有执行的请求消耗客户端的配额
+func (server *Server) allow(serviceMethod, client string) (refund func(), err error) {
+	server.rateMu.Lock()
+	defer server.rateMu.Unlock()
+	if len(server.rateLimits) == 0 {
+		return func() {}, nil
+	}
+	now := time.Now()
+	service := serviceMethod[:strings.LastIndex(serviceMethod, ".")]
+	var rules []*rateRule
+	var buckets []*tokenBucket
+	for _, pattern := range []string{serviceMethod, service} {
+		rule := server.rateLimits[pattern]
+		if rule == nil {
+			continue
+		}
+		b := rule.bucket(client, now)
+		if wait := b.refill(rule.RateLimit, now); wait > 0 {
+			return nil, fmt.Errorf("%s: %s, retry after %s", ErrRateLimited, pattern, wait.Round(time.Millisecond))
+		}
+		rules = append(rules, rule)
+		buckets = append(buckets, b)
+	}
+	for _, b := range buckets {
+		b.tokens--
+	}
+	return func() {
+		server.rateMu.Lock()
+		defer server.rateMu.Unlock()
+		for i, b := range buckets {
+			b.tokens = math.Min(b.tokens+1, float64(rules[i].Burst))
+		}
+	}, nil
+}
+
+type rateRule struct {
+	RateLimit
+	shared  tokenBucket
+	clients map[string]*tokenBucket
+}
+
+func (r *rateRule) bucket(client string, now time.Time) *tokenBucket {
+	if !r.PerClient {
+		return &r.shared
+	}
+	b := r.clients[client]
+	if b == nil {
+		if len(r.clients) >= maxIdleBuckets {
+			r.sweep(now)
+		}
+		b = new(tokenBucket)
+		r.clients[client] = b
+	}
+	return b
+}
+
+// sweep 删除已经回满的令牌桶，它们与新建的桶没有区别
+func (r *rateRule) sweep(now time.Time) {
+	for client, b := range r.clients {
+		if b.last.IsZero() || b.tokens+now.Sub(b.last).Seconds()*r.Rate >= float64(r.Burst) {
+			delete(r.clients, client)
+		}
+	}
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time //零值表示新建的桶，此时是满的
+}
+
+// refill 补充到 now 为止的令牌，返回距离有一个令牌还需要等待的时间
+func (b *tokenBucket) refill(l RateLimit, now time.Time) time.Duration {
+	if b.last.IsZero() {
+		b.tokens = float64(l.Burst)
+	} else {
+		b.tokens = math.Min(float64(l.Burst), b.tokens+now.Sub(b.last).Seconds()*l.Rate)
+	}
+	b.last = now
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / l.Rate * float64(time.Second))
+}
@@ -0,0 +1,60 @@
+package Orpc
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// ErrInternal 服务方法 panic 时返回给客户端的错误前缀，不会包含 panic 的内容，可以用 IsInternalError 判断
+const ErrInternal = "rpc server: internal error"
+
+// PanicHandler 服务方法 panic 时调用，stack 为 panic 所在 goroutine 的调用栈。
+// 返回的错误会作为响应发送给客户端，返回 nil 时发送默认的 ErrInternal 错误
+type PanicHandler func(serviceMethod string, recovered interface{}, stack []byte) error
+
+// SetPanicHandler 替换默认的 panic 处理：默认只记录带调用栈的日志
+func (server *Server) SetPanicHandler(h PanicHandler) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	server.panicHandler = h
+}
+
+// IsInternalError 判断 Call 返回的错误是否由服务端方法 panic 导致
+func IsInternalError(err error) bool {
+	return err != nil && strings.HasPrefix(err.Error(), ErrInternal)
+}
+
+// invoke 调用服务方法并把其中的 panic 转换为错误，进程不会因为某个方法的 panic 而退出
+func (server *Server) invoke(ctx context.Context, req *request) (err error) {
+	defer req.svc.calls.release()
+	defer req.release()
+	defer func() { req.adaptive.observe(time.Since(req.admitted)) }()
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+		atomic.AddUint64(&req.mtype.numPanics, 1)
+		stack := make([]byte, 64<<10)
+		stack = stack[:runtime.Stack(stack, false)]
+		err = server.recovered(req.header.ServiceMethod, r, stack)
+	}()
+	return req.svc.call(ctx, req.mtype, req.argv, req.replyv)
+}
+
+func (server *Server) recovered(serviceMethod string, r interface{}, stack []byte) error {
+	server.mu.Lock()
+	h := server.panicHandler
+	server.mu.Unlock()
+	if h == nil {
+		log.Printf("Orpc server: panic in %s: %v\n%s", serviceMethod, r, stack)
+	} else if err := h(serviceMethod, r, stack); err != nil {
+		return err
+	}
+	return fmt.Errorf("%s: panic in %s", ErrInternal, serviceMethod)
+}
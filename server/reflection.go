@@ -0,0 +1,135 @@
+package Orpc
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+)
+
+const (
+	reflectionServiceName = "Reflection"
+	ReflectionListMethod  = "Reflection.List"
+)
+
+// TypeInfo 参数或返回值类型的结构化描述，足以在没有生成代码的情况下构造出可以编码的值
+type TypeInfo struct {
+	Name   string      //具名类型的完整名称，例如 main.Args，匿名类型为空
+	Kind   string      //reflect.Kind 的名称，例如 struct、int、slice
+	Fields []FieldInfo //Kind 为 struct 时的导出字段
+	Elem   *TypeInfo   //Kind 为 ptr、slice、array、map 时的元素类型
+	Key    *TypeInfo   //Kind 为 map 时的 key 类型
+	Len    int         //Kind 为 array 时的长度
+	Ref    bool        //递归引用了外层已经描述过的同名类型，此时只有 Name 与 Kind
+}
+
+type FieldInfo struct {
+	Name string
+	Tag  string
+	Type *TypeInfo
+}
+
+type MethodInfo struct {
+	Name      string
+	ArgType   *TypeInfo
+	ReplyType *TypeInfo //方法签名中的指针类型
+	NumCalls  uint64
+	NumPanics uint64
+}
+
+type ServiceInfo struct {
+	Name    string
+	Methods []MethodInfo
+}
+
+// ReflectionRequest Service 为空时返回全部服务
+type ReflectionRequest struct {
+	Service string
+}
+
+type ReflectionResponse struct {
+	Services []ServiceInfo
+}
+
+// Reflection 每个 Server 自带的反射服务，以 Reflection 为服务名注册，可以通过 DisableReflection 关闭。
+// 它返回已注册的服务、方法以及参数和返回值的结构，供命令行工具和网关在没有桩代码的情况下调用
+type Reflection struct {
+	server *Server
+}
+
+func (r *Reflection) List(req ReflectionRequest, resp *ReflectionResponse) error {
+	services, err := r.server.describe(req.Service)
+	resp.Services = services
+	return err
+}
+
+// DisableReflection 不再对外提供 Reflection 服务
+func (server *Server) DisableReflection() {
+	server.serviceMap.Delete(reflectionServiceName)
+}
+
+// describe 返回 service 的描述，service 为空时返回全部服务，按名称排序
+func (server *Server) describe(service string) ([]ServiceInfo, error) {
+	var services []ServiceInfo
+	server.serviceMap.Range(func(namei, svci interface{}) bool {
+		if service != "" && namei.(string) != service {
+			return true
+		}
+		svc := svci.(*Service)
+		info := ServiceInfo{Name: svc.Name}
+		for name, mtype := range svc.Method {
+			info.Methods = append(info.Methods, MethodInfo{
+				Name:      name,
+				ArgType:   describeType(mtype.ArgType, nil),
+				ReplyType: describeType(mtype.ReplyType, nil),
+				NumCalls:  mtype.NumCalls(),
+				NumPanics: mtype.NumPanics(),
+			})
+		}
+		sort.Slice(info.Methods, func(i, j int) bool { return info.Methods[i].Name < info.Methods[j].Name })
+		services = append(services, info)
+		return true
+	})
+	if service != "" && len(services) == 0 {
+		return nil, errors.New("rpc server: service not found: " + service)
+	}
+	sort.Slice(services, func(i, j int) bool { return services[i].Name < services[j].Name })
+	return services, nil
+}
+
+// describeType visiting 为正在描述的外层具名类型，用于截断递归类型
+func describeType(t reflect.Type, visiting map[reflect.Type]bool) *TypeInfo {
+	info := &TypeInfo{Kind: t.Kind().String()}
+	if t.Name() != "" {
+		info.Name = t.String()
+		if visiting[t] {
+			info.Ref = true
+			return info
+		}
+		if t.Kind() == reflect.Struct {
+			if visiting == nil {
+				visiting = make(map[reflect.Type]bool)
+			}
+			visiting[t] = true
+			defer delete(visiting, t)
+		}
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if !f.IsExported() {
+				continue
+			}
+			info.Fields = append(info.Fields, FieldInfo{Name: f.Name, Tag: string(f.Tag), Type: describeType(f.Type, visiting)})
+		}
+	case reflect.Ptr, reflect.Slice:
+		info.Elem = describeType(t.Elem(), visiting)
+	case reflect.Array:
+		info.Elem = describeType(t.Elem(), visiting)
+		info.Len = t.Len()
+	case reflect.Map:
+		info.Key = describeType(t.Key(), visiting)
+		info.Elem = describeType(t.Elem(), visiting)
+	}
+	return info
+}
@@ -1,17 +1,23 @@
 package Orpc
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/R-Goys/Orpc/codec"
 	"io"
 	"log"
+	"maps"
 	"net"
 	"net/http"
 	"reflect"
+	"slices"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -24,26 +30,132 @@ const (
 
 type Server struct {
 	serviceMap sync.Map
+
+	mu         sync.Mutex
+	listeners  map[net.Listener]struct{}
+	conns      map[io.ReadWriteCloser]struct{}
+	onShutdown []func(ctx context.Context)
+	inShutdown atomic.Bool
+	connWg     sync.WaitGroup
+	health     *Health
+	onChange   []func(services []string)
+	strict     atomic.Bool
+	limiter    atomic.Pointer[limiter]
+	adaptive   atomic.Pointer[adaptiveLimiter]
+	clientID   func(info ConnInfo) string
+	rateMu     sync.Mutex
+	rateLimits map[string]*rateRule
+
+	panicHandler PanicHandler
 }
 
 func NewServer() *Server {
-	return &Server{
+	s := &Server{
 		serviceMap: sync.Map{},
 	}
+	s.health = newHealth(s)
+	health, _ := NewService(s.health)
+	reflection, _ := NewService(&Reflection{server: s})
+	s.serviceMap.Store(healthServiceName, health)
+	s.serviceMap.Store(reflectionServiceName, reflection)
+	return s
 }
 
-// Register 服务注册
+// Register 服务注册，签名不符合要求的导出方法会被跳过并记录日志，开启 EnableStrictRegister 后会返回错误。
+// 服务无效时返回 *RegistrationError
 func (server *Server) Register(rcvr interface{}) error {
-	s := NewService(rcvr)
+	return server.register(rcvr, "")
+}
+
+// RegisterName 与 Register 相同，但以 name 作为服务名，因此同一类型的多个实例可以以不同的名字注册
+func (server *Server) RegisterName(name string, rcvr interface{}) error {
+	if name == "" {
+		return &RegistrationError{Reason: "service name is empty"}
+	}
+	return server.register(rcvr, name)
+}
+
+// EnableStrictRegister 之后的 Register、RegisterName 在有导出方法不能作为服务方法时返回错误，而不是跳过这些方法
+func (server *Server) EnableStrictRegister() {
+	server.strict.Store(true)
+}
+
+func (server *Server) register(rcvr interface{}, name string) error {
+	s, err := newService(rcvr, name, server.strict.Load())
+	if err != nil {
+		return err
+	}
+	//只为使用者注册的服务打印日志，内置的 Health、Reflection 在包初始化时就会创建
+	for _, name := range slices.Sorted(maps.Keys(s.Method)) {
+		log.Printf("Orpc server: register Method: %s %s", name, s.Method[name].ArgType.String())
+	}
+	for _, m := range s.skipped {
+		log.Printf("Orpc server: skip Method: %s.%s: %s", s.Name, m.Name, m.Reason)
+	}
+	server.mu.Lock()
 	//使用的是并发安全的map，如果存在，则返回错误，
-	if _, ok := server.serviceMap.LoadOrStore(s.Name, s); ok {
+	_, ok := server.serviceMap.LoadOrStore(s.Name, s)
+	server.mu.Unlock()
+	if ok {
 		return errors.New("Orpc service already defined " + s.Name)
 	}
+	server.servicesChanged()
 	return nil
 }
 
+// Unregister 移除服务：新的请求会收到找不到服务的错误，Unregister 等待正在处理的请求结束后返回
+func (server *Server) Unregister(name string) error {
+	server.mu.Lock()
+	svci, ok := server.serviceMap.LoadAndDelete(name)
+	server.mu.Unlock()
+	if !ok {
+		return errors.New("rpc server: service not found: " + name)
+	}
+	svci.(*Service).calls.close()
+	server.servicesChanged()
+	return nil
+}
+
+// OnServicesChange 注册一个在服务被注册或移除后调用的函数，参数为变化后的 Services()，
+// 例如 Registry.ServerHeartBeat 借此立即把新的服务列表发布到注册中心
+func (server *Server) OnServicesChange(f func(services []string)) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	server.onChange = append(server.onChange, f)
+}
+
+func (server *Server) servicesChanged() {
+	server.mu.Lock()
+	hooks := append([]func([]string){}, server.onChange...)
+	server.mu.Unlock()
+	if len(hooks) == 0 {
+		return
+	}
+	services := server.Services()
+	for _, f := range hooks {
+		f(services)
+	}
+}
+
 func Register(rcvr interface{}) error { return DefaultServer.Register(rcvr) }
 
+// Services 返回已注册的服务名，按字典序排列，不包含自带的 Health 与 Reflection 服务
+func (server *Server) Services() []string {
+	var names []string
+	server.serviceMap.Range(func(namei, _ interface{}) bool {
+		if !isBuiltinService(namei.(string)) {
+			names = append(names, namei.(string))
+		}
+		return true
+	})
+	sort.Strings(names)
+	return names
+}
+
+func isBuiltinService(name string) bool {
+	return name == healthServiceName || name == reflectionServiceName
+}
+
 // FindService 根据服务来查找相应的方法并加载，
 func (server *Server) FindService(serviceMethod string) (svc *Service, mtype *MethodType, err error) {
 	dot := strings.LastIndex(serviceMethod, ".")
@@ -68,10 +180,17 @@ func (server *Server) FindService(serviceMethod string) (svc *Service, mtype *Me
 var DefaultServer = NewServer()
 
 func (s *Server) Accept(lis net.Listener) {
+	if !s.trackListener(lis, true) {
+		_ = lis.Close()
+		return
+	}
+	defer s.trackListener(lis, false)
 	for {
 		conn, err := lis.Accept()
 		if err != nil {
-			log.Println("Orpc server: Accept error ", err)
+			if !s.shuttingDown() {
+				log.Println("Orpc server: Accept error ", err)
+			}
 			return
 		}
 		go s.ServeConn(conn)
@@ -84,8 +203,13 @@ func Accept(lis net.Listener) {
 
 func (s *Server) ServeConn(conn io.ReadWriteCloser) {
 	defer func() { _ = conn.Close() }()
+	if !s.trackConn(conn, true) {
+		return
+	}
+	defer s.trackConn(conn, false)
 	var opt Option
-	if err := json.NewDecoder(conn).Decode(&opt); err != nil {
+	dec := json.NewDecoder(conn)
+	if err := dec.Decode(&opt); err != nil {
 		log.Println("Orpc server: options Decode error ", err)
 		return
 	}
@@ -98,14 +222,32 @@ func (s *Server) ServeConn(conn io.ReadWriteCloser) {
 		log.Println("Orpc server: invalid codec type ", opt.CodecType)
 		return
 	}
-	s.serveCodec(f(conn), &opt)
+	//json 解码器可能已经预读了 Option 之后的数据，去掉 json.Encoder 追加的换行后先交给编解码器
+	buffered, _ := io.ReadAll(dec.Buffered())
+	buffered = bytes.TrimPrefix(buffered, []byte("\n"))
+	info := ConnInfo{Metadata: opt.Metadata}
+	if c, ok := conn.(interface{ RemoteAddr() net.Addr }); ok {
+		info.RemoteAddr = c.RemoteAddr().String()
+	}
+	s.serveCodec(f(&bufferedConn{Reader: io.MultiReader(bytes.NewReader(buffered), conn), ReadWriteCloser: conn}), &opt, s.connClientID(info))
+}
+
+type bufferedConn struct {
+	io.Reader
+	io.ReadWriteCloser
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.Reader.Read(p)
 }
 
 var invalidRequest = struct{}{}
 
-func (s *Server) serveCodec(cc codec.Codec, opt *Option) {
+// serveCodec client 为按客户端限流时使用的客户端 ID
+func (s *Server) serveCodec(cc codec.Codec, opt *Option, client string) {
 	sending := new(sync.Mutex)
 	wg := new(sync.WaitGroup)
+	conn := s.limiter.Load().connSemaphore()
 
 	for {
 		req, err := s.readRequest(cc)
@@ -117,8 +259,27 @@ func (s *Server) serveCodec(cc codec.Codec, opt *Option) {
 			s.sendResponse(cc, req.header, invalidRequest, sending)
 			continue
 		}
+		refund, err := s.allow(req.header.ServiceMethod, client)
+		if err != nil {
+			s.reject(cc, req, err, sending)
+			continue
+		}
+		lim := s.limiter.Load()
+		if err = s.admit(req, lim, conn); err != nil {
+			refund()
+			s.reject(cc, req, err, sending)
+			continue
+		}
 		wg.Add(1)
-		go s.handleRequest(cc, req, sending, wg, opt.HandleTimeout)
+		//先写出拒绝的响应再 wg.Done，否则连接可能在响应写出之前被关闭
+		shed := func(err error) {
+			defer wg.Done()
+			req.release()
+			s.reject(cc, req, err, sending)
+		}
+		if !lim.dispatch(req.header.Priority, func() { s.handleRequest(cc, req, sending, wg, opt.HandleTimeout) }, shed) {
+			shed(errors.New(ErrResourceExhausted + ": request queue is full"))
+		}
 	}
 	wg.Wait()
 	_ = cc.Close()
@@ -129,12 +290,15 @@ type request struct {
 	argv, replyv reflect.Value
 	mtype        *MethodType
 	svc          *Service
+	release      func() //释放并发额度，方法返回时调用，超时后仍在执行的请求继续占用额度
+	adaptive     *adaptiveLimiter
+	admitted     time.Time
 }
 
 func (s *Server) readRequestHeader(cc codec.Codec) (*codec.Header, error) {
 	var h codec.Header
 	if err := cc.ReadHeader(&h); err != nil {
-		if err != io.EOF || !errors.Is(err, io.ErrUnexpectedEOF) {
+		if err != io.EOF && !errors.Is(err, io.ErrUnexpectedEOF) && !s.shuttingDown() {
 			log.Println("Orpc server: read header error ", err)
 		}
 		return nil, err
@@ -145,7 +309,6 @@ func (s *Server) readRequestHeader(cc codec.Codec) (*codec.Header, error) {
 func (s *Server) readRequest(cc codec.Codec) (*request, error) {
 	h, err := s.readRequestHeader(cc)
 	if err != nil {
-		log.Println("Orpc server: read header error ", err)
 		return nil, err
 	}
 	req := &request{
@@ -153,8 +316,14 @@ func (s *Server) readRequest(cc codec.Codec) (*request, error) {
 	}
 	//拿到服务实例和方法
 	req.svc, req.mtype, err = s.FindService(h.ServiceMethod)
+	if err == nil && !req.svc.calls.acquire() {
+		//服务正在被移除
+		err = errors.New("rpc server: service not found: " + req.svc.Name)
+	}
 	if err != nil {
-		return nil, err
+		//丢弃请求体，向客户端返回错误而不是断开连接
+		_ = cc.ReadBody(nil)
+		return req, err
 	}
 	//根据调用方法返回输入输出数值的指针
 	req.argv = req.mtype.NewArgv()
@@ -165,12 +334,20 @@ func (s *Server) readRequest(cc codec.Codec) (*request, error) {
 		argvi = req.argv.Addr().Interface()
 	}
 	if err = cc.ReadBody(argvi); err != nil {
+		req.svc.calls.release()
 		log.Println("Orpc server: read request body error", err)
 		return nil, err
 	}
 	return req, nil
 }
 
+// reject 向客户端返回 err，请求不会被执行
+func (s *Server) reject(cc codec.Codec, req *request, err error, sending *sync.Mutex) {
+	req.svc.calls.release()
+	req.header.Error = err.Error()
+	s.sendResponse(cc, req.header, invalidRequest, sending)
+}
+
 func (s *Server) sendResponse(cc codec.Codec, h *codec.Header, body interface{}, sending *sync.Mutex) {
 	sending.Lock()
 	defer sending.Unlock()
@@ -179,30 +356,45 @@ func (s *Server) sendResponse(cc codec.Codec, h *codec.Header, body interface{},
 	}
 }
 
+// handleRequest 每个请求只发送一次响应。timeout 大于 0 时方法在单独的 goroutine 中执行，
+// 超时后立即返回超时错误并取消 ctx，方法之后的返回值被丢弃；timeout 为 0 时不限制处理时间
 func (s *Server) handleRequest(cc codec.Codec, req *request, sending *sync.Mutex, wg *sync.WaitGroup, timeout time.Duration) {
 	defer wg.Done()
-	called := make(chan struct{})
-	sent := make(chan struct{})
-
-	go func() {
-		err := req.svc.Call(req.mtype, req.argv, req.replyv)
-		called <- struct{}{}
-		if err != nil {
-			req.header.Error = err.Error()
-			s.sendResponse(cc, req.header, invalidRequest, sending)
-			sent <- struct{}{}
-			return
+	//通过 RegisterFunc 注册的函数可以从 ctx 中取得请求的优先级，并在调用下游时继续使用
+	ctx := context.Background()
+	if req.header.Priority != 0 {
+		ctx = WithPriority(ctx, Priority(req.header.Priority))
+	}
+	var err error
+	if timeout <= 0 {
+		err = s.invoke(ctx, req)
+	} else {
+		//超时后 ctx 被取消，通过 RegisterFunc 注册的函数可以提前返回
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		//带缓冲，超时后方法返回时不会阻塞
+		called := make(chan error, 1)
+		go func() {
+			called <- s.invoke(ctx, req)
+		}()
+		select {
+		case err = <-called:
+		case <-ctx.Done():
+			select {
+			case err = <-called:
+			default:
+				req.header.Error = fmt.Sprintf("rpc server: request handle timeout: expect within %s", timeout)
+				s.sendResponse(cc, req.header, invalidRequest, sending)
+				return
+			}
 		}
-		s.sendResponse(cc, req.header, req.replyv.Interface(), sending)
-		sent <- struct{}{}
-	}()
-	select {
-	case <-called:
-		<-sent
-	case <-time.After(timeout):
-		req.header.Error = fmt.Sprintf("rpc server: request handle timeout: expect within %s", timeout)
+	}
+	if err != nil {
+		req.header.Error = err.Error()
 		s.sendResponse(cc, req.header, invalidRequest, sending)
+		return
 	}
+	s.sendResponse(cc, req.header, req.replyv.Interface(), sending)
 }
 
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -1,9 +1,12 @@
 package Orpc
 
 import (
+	"context"
+	"fmt"
 	"go/ast"
-	"log"
 	"reflect"
+	"strings"
+	"sync"
 	"sync/atomic"
 )
 
@@ -12,6 +15,8 @@ type MethodType struct {
 	ArgType   reflect.Type
 	ReplyType reflect.Type
 	numCalls  uint64
+	numPanics uint64
+	fn        reflect.Value //通过 RegisterFunc 注册的函数，此时 Method 为空
 }
 
 type Service struct {
@@ -19,12 +24,49 @@ type Service struct {
 	rcvr   reflect.Value //表示一个结构体实例，用于方法调用
 	typ    reflect.Type  //这表示一个结构体
 	Method map[string]*MethodType
+	calls  *callTracker //正在处理的请求，Unregister 时等待它们结束
+
+	skipped []SkippedMethod //注册时被跳过的导出方法
+}
+
+// callTracker 记录一个服务正在处理的请求，关闭后不再接受新的请求
+type callTracker struct {
+	mu     sync.Mutex
+	closed bool
+	wg     sync.WaitGroup
+}
+
+func (c *callTracker) acquire() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return false
+	}
+	c.wg.Add(1)
+	return true
+}
+
+func (c *callTracker) release() {
+	c.wg.Done()
+}
+
+// close 拒绝新的请求并等待已有的请求结束
+func (c *callTracker) close() {
+	c.mu.Lock()
+	c.closed = true
+	c.mu.Unlock()
+	c.wg.Wait()
 }
 
 func (m *MethodType) NumCalls() uint64 {
 	return atomic.LoadUint64(&m.numCalls)
 }
 
+// NumPanics 方法 panic 的次数，panic 的调用同样计入 NumCalls
+func (m *MethodType) NumPanics() uint64 {
+	return atomic.LoadUint64(&m.numPanics)
+}
+
 func (m *MethodType) NewArgv() reflect.Value {
 	var argv reflect.Value
 	if m.ArgType.Kind() == reflect.Ptr {
@@ -50,51 +92,121 @@ func (m *MethodType) NewReplyv() reflect.Value {
 	return repliv
 }
 
-func NewService(rcvr interface{}) *Service {
+// SkippedMethod 注册时被跳过的导出方法以及原因
+type SkippedMethod struct {
+	Name   string
+	Reason string
+}
+
+// RegistrationError 服务注册失败的原因。Reason 为服务本身的问题，Skipped 为所有不能作为服务方法的导出方法
+type RegistrationError struct {
+	Service string
+	Reason  string
+	Skipped []SkippedMethod
+}
+
+func (e *RegistrationError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "rpc server: register %s: %s", e.Service, e.Reason)
+	for i, m := range e.Skipped {
+		if i == 0 {
+			b.WriteString(" (")
+		} else {
+			b.WriteString("; ")
+		}
+		fmt.Fprintf(&b, "%s: %s", m.Name, m.Reason)
+	}
+	if len(e.Skipped) > 0 {
+		b.WriteString(")")
+	}
+	return b.String()
+}
+
+// NewService 以 rcvr 的类型名作为服务名创建服务，签名不符合 func(args Args, reply *Reply) error 的导出方法会被跳过，
+// 服务名未导出或者没有任何可用的方法时返回 *RegistrationError
+func NewService(rcvr interface{}) (*Service, error) {
+	return newService(rcvr, "", false)
+}
+
+// newService name 为空时以 rcvr 的类型名作为服务名，strict 为 true 时有导出方法被跳过也返回错误
+func newService(rcvr interface{}, name string, strict bool) (*Service, error) {
 	s := new(Service)
 	s.rcvr = reflect.ValueOf(rcvr)
-	s.Name = reflect.Indirect(s.rcvr).Type().Name()
+	if !s.rcvr.IsValid() {
+		return nil, &RegistrationError{Service: name, Reason: "receiver is nil"}
+	}
+	s.Name = name
+	if s.Name == "" {
+		s.Name = reflect.Indirect(s.rcvr).Type().Name()
+	}
 	s.typ = s.rcvr.Type()
+	s.calls = new(callTracker)
 	if !ast.IsExported(s.Name) {
-		log.Fatalf("rpc server: %s is not a valid service Name", s.Name)
+		return nil, &RegistrationError{Service: s.typ.String(), Reason: fmt.Sprintf("%q is not an exported service name", s.Name)}
 	}
 	s.registerMethods()
-	return s
+	if len(s.Method) == 0 {
+		return nil, &RegistrationError{Service: s.Name, Reason: "no exported methods of the form func(args Args, reply *Reply) error", Skipped: s.skipped}
+	}
+	if strict && len(s.skipped) > 0 {
+		return nil, &RegistrationError{Service: s.Name, Reason: "some exported methods can not be registered", Skipped: s.skipped}
+	}
+	return s, nil
 }
 
 func (s *Service) registerMethods() {
 	s.Method = make(map[string]*MethodType)
 	for i := 0; i < s.typ.NumMethod(); i++ {
 		method := s.typ.Method(i)
-		mType := method.Type
-		if mType.NumIn() != 3 || mType.NumOut() != 1 {
-			continue
-		}
-		if mType.Out(0) != reflect.TypeOf((*error)(nil)).Elem() {
-			continue
-		}
-		argType, replyType := mType.In(1), mType.In(2)
-		if !isExportedOrBuiltinType(argType) || !isExportedOrBuiltinType(replyType) {
+		if reason := checkMethod(method.Type); reason != "" {
+			s.skipped = append(s.skipped, SkippedMethod{Name: method.Name, Reason: reason})
 			continue
 		}
+		argType, replyType := method.Type.In(1), method.Type.In(2)
 		s.Method[method.Name] = &MethodType{
 			Method:    method,
 			ArgType:   argType,
 			ReplyType: replyType,
 		}
-		log.Printf("Orpc server: register Method: %s %s", method.Name, argType.String())
 	}
 }
 
+// checkMethod 返回方法不能作为服务方法的原因，mType 的第一个参数为接收者
+func checkMethod(mType reflect.Type) string {
+	if mType.NumIn() != 3 || mType.NumOut() != 1 {
+		return fmt.Sprintf("expect 2 arguments and 1 return value but got %d and %d", mType.NumIn()-1, mType.NumOut())
+	}
+	if mType.Out(0) != typeOfError {
+		return fmt.Sprintf("return type must be error but got %s", mType.Out(0))
+	}
+	argType, replyType := mType.In(1), mType.In(2)
+	if !isExportedOrBuiltinType(argType) {
+		return fmt.Sprintf("argument type %s is not exported", argType)
+	}
+	if replyType.Kind() != reflect.Ptr {
+		return fmt.Sprintf("reply type %s is not a pointer", replyType)
+	}
+	return ""
+}
+
 func isExportedOrBuiltinType(t reflect.Type) bool {
 	return ast.IsExported(t.Name()) || t.PkgPath() == ""
 }
 
 func (s *Service) Call(m *MethodType, argv, replyv reflect.Value) error {
+	return s.call(context.Background(), m, argv, replyv)
+}
+
+// call ctx 只会传给通过 RegisterFunc 注册的函数
+func (s *Service) call(ctx context.Context, m *MethodType, argv, replyv reflect.Value) error {
 	atomic.AddUint64(&m.numCalls, 1)
-	f := m.Method.Func
-	//反射的时候，调用函数
-	returnValues := f.Call([]reflect.Value{s.rcvr, argv, replyv})
+	var returnValues []reflect.Value
+	if m.fn.IsValid() {
+		returnValues = m.fn.Call([]reflect.Value{reflect.ValueOf(ctx), argv, replyv})
+	} else {
+		//反射的时候，调用函数
+		returnValues = m.Method.Func.Call([]reflect.Value{s.rcvr, argv, replyv})
+	}
 	if errInter := returnValues[0].Interface(); errInter != nil {
 		return errInter.(error)
 	}
@@ -0,0 +1,108 @@
+package Orpc
+
+import (
+	"context"
+	"io"
+	"net"
+	"time"
+)
+
+// RegisterOnShutdown 注册一个在 Shutdown 开始时同步调用的函数，例如从注册中心注销自己，
+// 这些函数在关闭监听之前执行，ctx 即 Shutdown 的 ctx
+func (s *Server) RegisterOnShutdown(f func(ctx context.Context)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onShutdown = append(s.onShutdown, f)
+}
+
+// Shutdown 优雅关闭：把健康状态置为 NOT_SERVING，执行 RegisterOnShutdown 注册的函数，关闭所有监听，停止读取新的请求，
+// 等待已经在处理的请求返回后关闭连接。ctx 结束时强制关闭剩余的连接并返回 ctx.Err()
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	if s.inShutdown.Swap(true) {
+		s.mu.Unlock()
+		return nil
+	}
+	hooks := append([]func(context.Context){}, s.onShutdown...)
+	s.mu.Unlock()
+	if s.health != nil {
+		s.health.startShutdown()
+	}
+	for _, f := range hooks {
+		f(ctx)
+	}
+
+	s.mu.Lock()
+	for lis := range s.listeners {
+		_ = lis.Close()
+	}
+	for conn := range s.conns {
+		//打断阻塞中的读取，serveCodec 会在处理完已读到的请求后退出
+		if c, ok := conn.(interface{ SetReadDeadline(time.Time) error }); ok {
+			_ = c.SetReadDeadline(time.Now())
+		} else {
+			_ = conn.Close()
+		}
+	}
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.connWg.Wait()
+		s.limiter.Load().close()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		s.mu.Lock()
+		for conn := range s.conns {
+			_ = conn.Close()
+		}
+		s.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+func (s *Server) shuttingDown() bool {
+	return s.inShutdown.Load()
+}
+
+// trackListener 记录或移除一个监听，正在关闭时拒绝新的监听
+func (s *Server) trackListener(lis net.Listener, add bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !add {
+		delete(s.listeners, lis)
+		return true
+	}
+	if s.shuttingDown() {
+		return false
+	}
+	if s.listeners == nil {
+		s.listeners = make(map[net.Listener]struct{})
+	}
+	s.listeners[lis] = struct{}{}
+	return true
+}
+
+// trackConn 记录或移除一个连接，正在关闭时拒绝新的连接
+func (s *Server) trackConn(conn io.ReadWriteCloser, add bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !add {
+		delete(s.conns, conn)
+		s.connWg.Done()
+		return true
+	}
+	if s.shuttingDown() {
+		return false
+	}
+	if s.conns == nil {
+		s.conns = make(map[io.ReadWriteCloser]struct{})
+	}
+	s.conns[conn] = struct{}{}
+	s.connWg.Add(1)
+	return true
+}
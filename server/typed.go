@@ -0,0 +1,124 @@
+package Orpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// CallTyped 以 Req 为参数调用 method，并把返回值解码为 Resp
+func CallTyped[Req, Resp any](ctx context.Context, c *Client, method string, req Req) (Resp, error) {
+	var resp Resp
+	err := c.Call(ctx, method, req, &resp)
+	return resp, err
+}
+
+// Method 带类型的方法描述，例如 var sum = &Orpc.Method[Args, int]{Name: "Foo.Sum"}。
+// 第一次调用时通过服务端的 Reflection 服务检查 Req、Resp 与服务端方法的参数、返回值结构是否一致，
+// 不一致时这次以及之后的调用都返回错误；服务端关闭了 Reflection 时不做检查
+type Method[Req, Resp any] struct {
+	Name string
+
+	mu        sync.Mutex
+	validated bool
+	err       error
+}
+
+func (m *Method[Req, Resp]) Call(ctx context.Context, c Caller, req Req) (Resp, error) {
+	var resp Resp
+	if err := m.validate(ctx, c); err != nil {
+		return resp, err
+	}
+	err := c.Call(ctx, m.Name, req, &resp)
+	return resp, err
+}
+
+// validate 只缓存确定的结果，调用 Reflection 出错（例如网络错误、服务还没有注册）时下一次调用会重新检查
+func (m *Method[Req, Resp]) validate(ctx context.Context, c Caller) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.validated {
+		return m.err
+	}
+	//与 FindService 一致，服务名可以包含点
+	dot := strings.LastIndex(m.Name, ".")
+	if dot < 0 {
+		m.validated, m.err = true, fmt.Errorf("rpc method: invalid name %q, expect Service.Method", m.Name)
+		return m.err
+	}
+	service, method := m.Name[:dot], m.Name[dot+1:]
+	var resp ReflectionResponse
+	if err := c.Call(ctx, ReflectionListMethod, ReflectionRequest{Service: service}, &resp); err != nil {
+		if err.Error() == "rpc server: service not found: "+reflectionServiceName {
+			m.validated = true
+			return nil
+		}
+		return err
+	}
+	if len(resp.Services) == 0 {
+		return errors.New("rpc server: service not found: " + service)
+	}
+	m.validated = true
+	for _, info := range resp.Services[0].Methods {
+		if info.Name != method {
+			continue
+		}
+		req, reply := describeType(reflect.TypeOf((*Req)(nil)).Elem(), nil), describeType(reflect.TypeOf((*Resp)(nil)).Elem(), nil)
+		if path, ok := compatible(req, info.ArgType, "args"); !ok {
+			m.err = fmt.Errorf("rpc method %s: %s does not match the server", m.Name, path)
+		} else if path, ok = compatible(reply, info.ReplyType, "reply"); !ok {
+			m.err = fmt.Errorf("rpc method %s: %s does not match the server", m.Name, path)
+		}
+		return m.err
+	}
+	m.err = fmt.Errorf("rpc server: method not found: %s", method)
+	return m.err
+}
+
+// compatible 按 gob 的规则比较两个类型的结构：指针被展开，类型名不重要，结构体的字段名与字段类型需要一致。
+// 不一致时返回第一个不一致的位置
+func compatible(a, b *TypeInfo, path string) (string, bool) {
+	for a.Kind == "ptr" {
+		a = a.Elem
+	}
+	for b.Kind == "ptr" {
+		b = b.Elem
+	}
+	if a.Ref || b.Ref {
+		//递归类型只比较名称以外的种类
+		return path, a.Kind == b.Kind
+	}
+	if a.Kind != b.Kind {
+		return path, false
+	}
+	switch a.Kind {
+	case "struct":
+		if len(a.Fields) != len(b.Fields) {
+			return path, false
+		}
+		fields := make(map[string]*TypeInfo, len(b.Fields))
+		for _, f := range b.Fields {
+			fields[f.Name] = f.Type
+		}
+		for _, f := range a.Fields {
+			t, ok := fields[f.Name]
+			if !ok {
+				return path + "." + f.Name, false
+			}
+			if p, ok := compatible(f.Type, t, path+"."+f.Name); !ok {
+				return p, false
+			}
+		}
+	case "slice", "array":
+		return compatible(a.Elem, b.Elem, path+"[]")
+	case "map":
+		if p, ok := compatible(a.Key, b.Key, path+"[key]"); !ok {
+			return p, false
+		}
+		return compatible(a.Elem, b.Elem, path+"[value]")
+	}
+	return path, true
+}